@@ -11,10 +11,132 @@ type PluginSettings struct {
 	Path    string                `json:"path"`
 	InitSql string                `json:"initSql"`
 	Secrets *SecretPluginSettings `json:"-"`
+
+	// Mode is the kind of database the path points at: memory, file or
+	// motherduck. Unset derives it from the path.
+	Mode string `json:"mode"`
+
+	// Extensions are installed and loaded before InitSql runs.
+	Extensions []string `json:"extensions"`
+	// ExtensionRepository replaces the public extension repository, e.g. with a
+	// mirror in air-gapped deployments. ExtensionVersions pins the version
+	// installed per extension.
+	ExtensionRepository string            `json:"extensionRepository"`
+	ExtensionVersions   map[string]string `json:"extensionVersions"`
+	// AutoinstallKnownExtensions and AutoloadKnownExtensions control whether
+	// DuckDB installs and loads the extensions a query needs on its own. Unset
+	// keeps DuckDB's defaults.
+	AutoinstallKnownExtensions *bool `json:"autoinstallKnownExtensions"`
+	AutoloadKnownExtensions    *bool `json:"autoloadKnownExtensions"`
+	// MemoryLimit (e.g. "4GB") and Threads override DuckDB's resource defaults.
+	MemoryLimit string `json:"memoryLimit"`
+	Threads     int    `json:"threads"`
+	// TempDirectory is where DuckDB spills large sorts and aggregations to disk.
+	TempDirectory string `json:"tempDirectory"`
+	// DuckDBSettings are applied with SET after the other boot queries.
+	DuckDBSettings map[string]string `json:"duckdbSettings"`
+	// Collation is DuckDB's default_collation for comparing and ordering
+	// strings, e.g. nocase or an ICU locale like de.
+	Collation string `json:"collation"`
+	// MotherDuckTokenSource is where the MotherDuck token is read from:
+	// literal (the default) uses the motherDuckToken secure setting, env and
+	// file read it from the environment variable or file named by
+	// MotherDuckTokenRef.
+	MotherDuckTokenSource string `json:"motherDuckTokenSource"`
+	MotherDuckTokenRef    string `json:"motherDuckTokenRef"`
+	// MotherDuckAlias is the name the md: path is ATTACHed as. DuckDB derives
+	// the name from the path when unset.
+	MotherDuckAlias string `json:"motherDuckAlias"`
+	// SearchPath is a comma separated list of catalog or catalog.schema names
+	// that unqualified table names are resolved against.
+	SearchPath string `json:"searchPath"`
+	// Attachments are ATTACHed after the extensions are loaded.
+	Attachments []Attachment `json:"attachments"`
+	// ReadOnly opens local database files in read-only mode.
+	ReadOnly bool `json:"readOnly"`
+	// InitSqlContinueOnError logs failing InitSql statements and runs the
+	// remaining ones instead of failing the connection.
+	InitSqlContinueOnError bool `json:"initSqlContinueOnError"`
+
+	// Connection pool settings, zero keeps the database/sql defaults.
+	MaxOpenConns           int `json:"maxOpenConns"`
+	MaxIdleConns           int `json:"maxIdleConns"`
+	ConnMaxLifetimeSeconds int `json:"connMaxLifetimeSeconds"`
+
+	// HugeIntAsFloat outputs HUGEINT and UHUGEINT columns as float64 instead of
+	// strings, accepting the loss of precision.
+	HugeIntAsFloat bool `json:"hugeIntAsFloat"`
+	// DecimalAsString outputs DECIMAL columns as exact strings instead of float64.
+	DecimalAsString bool `json:"decimalAsString"`
+	// FlattenStructs outputs each field of top-level STRUCT columns as its own
+	// column instead of one JSON column.
+	FlattenStructs bool `json:"flattenStructs"`
+	// ExpandArrays outputs each element of top-level fixed-size ARRAY columns
+	// as its own column instead of one JSON column.
+	ExpandArrays bool `json:"expandArrays"`
+	// QueryTimeout is a duration string (e.g. "5m"). Defaults to 30s when unset.
+	QueryTimeout string `json:"queryTimeout"`
+	// MaxQueryTimeout caps the timeout a single query may ask for with the
+	// queryTimeout field of its model.
+	MaxQueryTimeout string `json:"maxQueryTimeout"`
+	// ForwardHeaders forwards the Grafana request headers to the queries and
+	// records the querying user in the grafana_user DuckDB variable.
+	ForwardHeaders bool `json:"forwardHeaders"`
+	// RetryOn lists error substrings for which failed queries are retried.
+	// Retries and Pause (in seconds) default to 3 and 100 when unset.
+	RetryOn []string `json:"retryOn"`
+	Retries *int     `json:"retries"`
+	Pause   *int     `json:"pause"`
+	// RemoteRetries is how often a query failing with a transient error of a
+	// remote read, e.g. a 503 from S3, is retried on its connection, waiting
+	// RemoteRetryBackoff before the first retry and twice as long before each
+	// following one. RemoteRetryOn replaces the error substrings considered
+	// transient.
+	RemoteRetries      *int     `json:"remoteRetries"`
+	RemoteRetryBackoff string   `json:"remoteRetryBackoff"`
+	RemoteRetryOn      []string `json:"remoteRetryOn"`
+	// MaxConcurrentQueries caps the number of queries running at once, the
+	// others wait for up to the query timeout. Unlimited when unset.
+	MaxConcurrentQueries int `json:"maxConcurrentQueries"`
+	// UseQueryTimezone applies the dashboard timezone sent with each query to
+	// the macros and the DuckDB TimeZone setting instead of UTC.
+	UseQueryTimezone bool `json:"useQueryTimezone"`
+	// QueryOnly rejects every statement that is not a query, like INSERT or
+	// ATTACH, before it runs.
+	QueryOnly bool `json:"queryOnly"`
+	// MultiStatements runs queries holding several statements, like a CREATE
+	// TEMP TABLE followed by a SELECT, returning the result of the last one.
+	// Such queries are rejected when unset.
+	MultiStatements bool `json:"multiStatements"`
+	// MaxRows caps the number of rows returned by a query, longer results are
+	// truncated with a warning. Unlimited when unset.
+	MaxRows int64 `json:"maxRows"`
+	// CacheTTL is a duration string (e.g. "5m") that enables the in-memory
+	// query result cache, "0" disables it. It takes precedence over
+	// CacheTTLSeconds, which enables the cache when greater than zero.
+	CacheTTL        string `json:"cacheTTL"`
+	CacheTTLSeconds int    `json:"cacheTtlSeconds"`
+	// CacheMaxEntries bounds the number of cached results. Defaults to 100 when unset.
+	CacheMaxEntries int `json:"cacheMaxEntries"`
+}
+
+// Attachment is an additional database ATTACHed on boot.
+type Attachment struct {
+	Alias    string `json:"alias"`
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	ReadOnly bool   `json:"readOnly"`
 }
 
 type SecretPluginSettings struct {
 	MotherDuckToken string `json:"motherduckToken"`
+	// Cloud storage credentials, turned into a DuckDB secret. CloudProvider is
+	// one of s3 (the default), gcs or azure.
+	CloudProvider string `json:"cloudProvider"`
+	CloudKeyID    string `json:"cloudKeyId"`
+	CloudSecret   string `json:"cloudSecret"`
+	CloudRegion   string `json:"cloudRegion"`
+	CloudEndpoint string `json:"cloudEndpoint"`
 }
 
 func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSettings, error) {
@@ -31,5 +153,10 @@ func LoadPluginSettings(source backend.DataSourceInstanceSettings) (*PluginSetti
 func loadSecretPluginSettings(source map[string]string) *SecretPluginSettings {
 	return &SecretPluginSettings{
 		MotherDuckToken: source["motherDuckToken"],
+		CloudProvider:   source["cloudProvider"],
+		CloudKeyID:      source["cloudKeyId"],
+		CloudSecret:     source["cloudSecret"],
+		CloudRegion:     source["cloudRegion"],
+		CloudEndpoint:   source["cloudEndpoint"],
 	}
 }
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// annotationQueryType marks queries that return annotation events.
+const annotationQueryType = "annotation"
+
+var errNoAnnotationTime = errors.New(`annotation queries must return a "time" column`)
+
+// annotationResponse turns the result of an annotation query into annotation
+// events. Failed responses are returned unchanged.
+func annotationResponse(res backend.DataResponse) backend.DataResponse {
+	if res.Error != nil {
+		return res
+	}
+	frames := make(data.Frames, 0, len(res.Frames))
+	for _, frame := range res.Frames {
+		annotations, err := annotationFrame(frame)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		frames = append(frames, annotations)
+	}
+	res.Frames = frames
+	return res
+}
+
+// annotationFrame maps the time, timeEnd, text and tags columns of a query
+// result to an annotation frame. Only time is required. The time columns may be
+// timestamps, which the converters already return in UTC, or epoch
+// milliseconds. Tags are a comma separated string or a JSON array of strings.
+func annotationFrame(frame *data.Frame) (*data.Frame, error) {
+	timeField, _ := frame.FieldByName("time")
+	if timeField == nil {
+		return nil, errNoAnnotationTime
+	}
+	timeEndField, _ := frame.FieldByName("timeEnd")
+	textField, _ := frame.FieldByName("text")
+	tagsField, _ := frame.FieldByName("tags")
+
+	rows := timeField.Len()
+	times := make([]*time.Time, rows)
+	timeEnds := make([]*time.Time, rows)
+	texts := make([]*string, rows)
+	tags := make([]*string, rows)
+	for i := 0; i < rows; i++ {
+		t, err := annotationTime(timeField, i)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return nil, fmt.Errorf("annotation time in row %d is NULL", i+1)
+		}
+		times[i] = t
+		if timeEndField != nil {
+			if timeEnds[i], err = annotationTime(timeEndField, i); err != nil {
+				return nil, err
+			}
+		}
+		if textField != nil {
+			if v, ok := textField.ConcreteAt(i); ok {
+				text := fmt.Sprint(v)
+				texts[i] = &text
+			}
+		}
+		if tagsField != nil {
+			if v, ok := tagsField.ConcreteAt(i); ok {
+				if tags[i], err = annotationTags(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	fields := []*data.Field{data.NewField("time", nil, times)}
+	if timeEndField != nil {
+		fields = append(fields, data.NewField("timeEnd", nil, timeEnds))
+	}
+	fields = append(fields, data.NewField("text", nil, texts), data.NewField("tags", nil, tags))
+	return data.NewFrame(frame.Name, fields...), nil
+}
+
+func annotationTime(field *data.Field, i int) (*time.Time, error) {
+	v, ok := field.ConcreteAt(i)
+	if !ok {
+		return nil, nil
+	}
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case int64:
+		t = time.UnixMilli(v)
+	case int32:
+		t = time.UnixMilli(int64(v))
+	case float64:
+		t = time.UnixMilli(int64(v))
+	default:
+		return nil, fmt.Errorf("annotation column %q must be a timestamp or epoch milliseconds, got %s", field.Name, field.Type())
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+func annotationTags(v any) (*string, error) {
+	var tags []string
+	switch v := v.(type) {
+	case string:
+		// JSON columns are converted to JSON text.
+		if !strings.HasPrefix(strings.TrimSpace(v), "[") && !strings.HasPrefix(strings.TrimSpace(v), "{") {
+			tags = strings.Split(v, ",")
+			break
+		}
+		if err := json.Unmarshal([]byte(v), &tags); err != nil {
+			return nil, fmt.Errorf("annotation tags must be a JSON array of strings: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("annotation tags must be a string or a list of strings, got %T", v)
+	}
+
+	cleaned := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			cleaned = append(cleaned, tag)
+		}
+	}
+	joined := strings.Join(cleaned, ",")
+	return &joined, nil
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func runAnnotationQuery(t *testing.T, rawSQL string) backend.DataResponse {
+	t.Helper()
+	ds := newTestDatasource(t, `{"path": ""}`)
+	model, err := json.Marshal(map[string]any{"rawSql": rawSQL, "format": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runDataQuery(t, ds, backend.DataQuery{RefID: "Anno", QueryType: annotationQueryType, JSON: model})
+}
+
+func TestAnnotationQuery(t *testing.T) {
+	res := runAnnotationQuery(t, `SELECT * FROM (VALUES
+		(TIMESTAMPTZ '2024-03-10 10:00:00+00', TIMESTAMP '2024-03-10 11:00:00', 'deploy v1', 'deploy, prod', 1),
+		(TIMESTAMPTZ '2024-03-10 12:00:00+02', NULL, NULL, NULL, 2)
+	) t(time, timeEnd, text, tags, ignored)`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(res.Frames) != 1 {
+		t.Fatalf("expected 1 frame, got %d", len(res.Frames))
+	}
+	frame := res.Frames[0]
+
+	var names []string
+	for _, field := range frame.Fields {
+		names = append(names, field.Name)
+	}
+	if expected := []string{"time", "timeEnd", "text", "tags"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected fields %q, got %q", expected, names)
+	}
+	assertField(t, frame, "time", data.FieldTypeNullableTime, []any{
+		time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC),
+	})
+	assertField(t, frame, "timeEnd", data.FieldTypeNullableTime, []any{time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC), nil})
+	assertField(t, frame, "text", data.FieldTypeNullableString, []any{"deploy v1", nil})
+	assertField(t, frame, "tags", data.FieldTypeNullableString, []any{"deploy,prod", nil})
+}
+
+func TestAnnotationQueryEpochAndJSONTags(t *testing.T) {
+	res := runAnnotationQuery(t, `SELECT 1710064800000 AS time, 42 AS text, '["a", " b "]'::JSON AS tags`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	assertField(t, frame, "time", data.FieldTypeNullableTime, []any{time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC)})
+	assertField(t, frame, "text", data.FieldTypeNullableString, []any{"42"})
+	assertField(t, frame, "tags", data.FieldTypeNullableString, []any{"a,b"})
+}
+
+func TestAnnotationQueryErrors(t *testing.T) {
+	for rawSQL, message := range map[string]string{
+		`SELECT now() AS ts, 'x' AS text`:                `must return a "time" column`,
+		`SELECT NULL::TIMESTAMP AS time`:                 "annotation time in row 1 is NULL",
+		`SELECT 'yesterday' AS time`:                     `annotation column "time" must be a timestamp or epoch milliseconds`,
+		`SELECT now() AS time, '{"a": 1}'::JSON AS tags`: "annotation tags must be a JSON array of strings",
+		`SELECT now() AS time, 1 AS tags`:                "annotation tags must be a string or a list of strings",
+	} {
+		res := runAnnotationQuery(t, rawSQL)
+		if res.Error == nil || !strings.Contains(res.Error.Error(), message) {
+			t.Errorf("%s: expected %q, got %v", rawSQL, message, res.Error)
+		}
+		if res.Status != backend.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", rawSQL, backend.StatusBadRequest, res.Status)
+		}
+	}
+
+	if _, err := annotationFrame(data.NewFrame("")); !errors.Is(err, errNoAnnotationTime) {
+		t.Errorf("expected errNoAnnotationTime for an empty frame, got %v", err)
+	}
+}
+
+func TestAnnotationQueryTypeOnly(t *testing.T) {
+	// Regular queries with a time column are left alone.
+	ds := newTestDatasource(t, `{"path": ""}`)
+	res := runQuery(t, ds, `SELECT TIMESTAMP '2024-03-10 10:00:00' AS time, 1 AS value`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(res.Frames[0].Fields) != 2 || res.Frames[0].Fields[1].Name != "value" {
+		t.Errorf("expected the query result unchanged, got %v", res.Frames[0].Fields)
+	}
+}
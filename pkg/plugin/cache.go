@@ -0,0 +1,207 @@
+package plugin
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+const defaultCacheMaxEntries = 100
+
+// resultCache is an opt-in, in-memory cache of query results for a single
+// datasource instance.
+//
+// Entries are keyed by the interpolated SQL, normalized so that formatting
+// changes share an entry, together with the query time range. The time range
+// is truncated to a multiple of the TTL, so repeated refreshes of a relative
+// range (e.g. "now-1h" to "now") within the same TTL window share a key.
+// Macros that put the exact bounds into the SQL text (like $__timeFrom) still
+// produce a new key on every refresh.
+//
+// Entries are invalidated when they expire, when the datasource reloads a
+// modified DuckDB file and when the instance is disposed. The cache holds at
+// most maxEntries results; when full, expired entries are dropped first and then
+// the least recently used entry is evicted.
+type resultCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	// recent orders the entries from the most to the least recently used.
+	recent *list.List
+	now    func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	frames  data.Frames
+	expires time.Time
+}
+
+// cacheTTL returns the TTL of the result cache, zero when it is disabled. The
+// cacheTTL duration takes precedence over cacheTtlSeconds.
+func cacheTTL(config *models.PluginSettings) (time.Duration, error) {
+	value := strings.TrimSpace(config.CacheTTL)
+	if value == "" {
+		return time.Duration(max(config.CacheTTLSeconds, 0)) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, &ConfigError{"Invalid cache TTL: " + value + " -> example input: 5m, or 0 to disable the cache"}
+	}
+	return ttl, nil
+}
+
+func newResultCache(ttl time.Duration, maxEntries int) *resultCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &resultCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		recent:     list.New(),
+		now:        time.Now,
+	}
+}
+
+// Key builds the cache key for an already interpolated query.
+func (c *resultCache) Key(q *sqlutil.Query) string {
+	from := q.TimeRange.From.Truncate(c.ttl).Unix()
+	to := q.TimeRange.To.Truncate(c.ttl).Unix()
+	fill := ""
+	if q.FillMissing != nil {
+		fill = fmt.Sprintf("%d:%v", q.FillMissing.Mode, q.FillMissing.Value)
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\x00%d\x00%d", q.RefID, normalizeSQL(q.RawSQL), q.Format, fill, q.ConnectionArgs, from, to)
+}
+
+func (c *resultCache) Get(key string) (data.Frames, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return entry.frames, true
+}
+
+func (c *resultCache) Set(key string, frames data.Frames) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry{key: key, frames: frames, expires: now.Add(c.ttl)}
+		c.recent.MoveToFront(element)
+		return
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = c.recent.PushFront(&cacheEntry{key: key, frames: frames, expires: now.Add(c.ttl)})
+}
+
+// Purge drops all cached results.
+func (c *resultCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.recent.Init()
+}
+
+// Len returns the number of cached results, including expired ones that have
+// not been dropped yet.
+func (c *resultCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// evict makes room for one entry. Must be called with c.mu held.
+func (c *resultCache) evict(now time.Time) {
+	for _, element := range c.entries {
+		if !now.Before(element.Value.(*cacheEntry).expires) {
+			c.remove(element)
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops an entry. Must be called with c.mu held.
+func (c *resultCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// normalizeSQL collapses the whitespace of sql outside of literals and
+// comments and drops trailing semicolons. Line comments keep their newline, so
+// different statements never share a key.
+func normalizeSQL(sql string) string {
+	var b strings.Builder
+	space := false
+	write := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+		case c == '\'' || c == '"':
+			end := skipQuoted(sql, i, c)
+			write(sql[i:end])
+			i = end - 1
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i - 1
+			}
+			write(sql[i : i+end+1])
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 4
+			}
+			write(sql[i : i+2+end+2])
+			i += 2 + end + 1
+		case c == '$':
+			tag, ok := dollarQuoteTag(sql[i:])
+			end := -1
+			if ok {
+				end = strings.Index(sql[i+len(tag):], tag)
+			}
+			if end < 0 {
+				write("$")
+				continue
+			}
+			write(sql[i : i+len(tag)+end+len(tag)])
+			i += len(tag) + end + len(tag) - 1
+		default:
+			write(sql[i : i+1])
+		}
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
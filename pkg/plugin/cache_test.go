@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+func TestResultCacheExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newResultCache(time.Minute, 10)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", data.Frames{data.NewFrame("a")})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a cache hit")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the entry to expire after the TTL")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expected expired entry to be dropped, got %d entries", c.Len())
+	}
+}
+
+func TestResultCacheMaxEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newResultCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", data.Frames{})
+	now = now.Add(time.Second)
+	c.Set("b", data.Frames{})
+	now = now.Add(time.Second)
+	c.Set("c", data.Frames{})
+
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected the oldest entry to be evicted")
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %q to be cached", key)
+		}
+	}
+}
+
+func TestResultCacheKeyBucketsTimeRange(t *testing.T) {
+	c := newResultCache(time.Minute, 0)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	query := func(offset time.Duration) *sqlutil.Query {
+		return &sqlutil.Query{
+			RefID:     "A",
+			RawSQL:    "SELECT 1",
+			TimeRange: backend.TimeRange{From: base.Add(-time.Hour + offset), To: base.Add(offset)},
+		}
+	}
+
+	if c.Key(query(0)) != c.Key(query(30*time.Second)) {
+		t.Error("expected time ranges within the same TTL bucket to share a key")
+	}
+	if c.Key(query(0)) == c.Key(query(time.Minute)) {
+		t.Error("expected time ranges in different TTL buckets to have different keys")
+	}
+}
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newResultCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", data.Frames{})
+	c.Set("b", data.Frames{})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a cache hit")
+	}
+	c.Set("c", data.Frames{})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %q to be cached", key)
+		}
+	}
+
+	// Expired entries are dropped before a used one is evicted.
+	now = now.Add(30 * time.Second)
+	c.Set("a", data.Frames{})
+	now = now.Add(30 * time.Second)
+	c.Set("d", data.Frames{})
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected the refreshed entry to be kept")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestResultCacheKeyNormalizesSQL(t *testing.T) {
+	c := newResultCache(time.Minute, 0)
+	key := func(rawSQL string) string {
+		return c.Key(&sqlutil.Query{RefID: "A", RawSQL: rawSQL})
+	}
+
+	same := []string{
+		"SELECT a, b FROM t WHERE x = 'a  b'",
+		"  SELECT a,  b\n\tFROM t\r\n WHERE x = 'a  b';  ",
+		"SELECT a, b FROM t WHERE x = 'a  b';;",
+	}
+	for _, rawSQL := range same[1:] {
+		if key(rawSQL) != key(same[0]) {
+			t.Errorf("expected %q to share the key of %q", rawSQL, same[0])
+		}
+	}
+
+	different := []string{
+		"SELECT a, b FROM t WHERE x = 'a b'",
+		`SELECT a, b FROM t WHERE x = "a  b"`,
+		"SELECT a, b FROM t WHERE x = $$a b$$",
+		"SELECT a, b -- FROM t WHERE x = 'a  b'",
+	}
+	for _, rawSQL := range different {
+		if key(rawSQL) == key(same[0]) {
+			t.Errorf("expected %q to have a key of its own", rawSQL)
+		}
+	}
+	if normalizeSQL("SELECT 1 -- one\n, 2") == normalizeSQL("SELECT 1 -- one , 2") {
+		t.Error("expected line comments to keep their newline")
+	}
+	if got := normalizeSQL("SELECT $$a  b$$,  /* c  d */ 1"); got != "SELECT $$a  b$$, /* c  d */ 1" {
+		t.Errorf("expected literals and comments to be kept, got %q", got)
+	}
+}
+
+func TestCacheTTLSetting(t *testing.T) {
+	tests := []struct {
+		config   models.PluginSettings
+		expected time.Duration
+	}{
+		{models.PluginSettings{}, 0},
+		{models.PluginSettings{CacheTTLSeconds: 30}, 30 * time.Second},
+		{models.PluginSettings{CacheTTLSeconds: -1}, 0},
+		{models.PluginSettings{CacheTTL: "5m"}, 5 * time.Minute},
+		{models.PluginSettings{CacheTTL: "0", CacheTTLSeconds: 30}, 0},
+		{models.PluginSettings{CacheTTL: " 1h ", CacheTTLSeconds: 30}, time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := cacheTTL(&tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.expected {
+			t.Errorf("%+v: expected %v, got %v", tt.config, tt.expected, got)
+		}
+	}
+
+	for _, invalid := range []string{"soon", "-5m", "5"} {
+		var configErr *ConfigError
+		if _, err := cacheTTL(&models.PluginSettings{CacheTTL: invalid}); !errors.As(err, &configErr) {
+			t.Errorf("expected a ConfigError for %q, got %v", invalid, err)
+		}
+	}
+}
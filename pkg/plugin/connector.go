@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"context"
+	"database/sql/driver"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// resultConnector hands out connections that reshape query results before
+// sqlds turns them into frames: STRUCT columns are flattened and ARRAY columns
+// expanded when enabled and the row limit of the query context is applied.
+// The attempts and the result columns of a query are recorded for its context
+// as well. Transient errors of remote reads are retried on the connection.
+type resultConnector struct {
+	*duckdb.Connector
+	flattenStructs bool
+	expandArrays   bool
+	remoteRetry    remoteRetry
+	// release hands a shared connector back to the connector cache, which
+	// closes it once no instance uses it anymore.
+	release func() error
+}
+
+// Close is called by sql.DB.Close.
+func (c *resultConnector) Close() error {
+	if c.release != nil {
+		return c.release()
+	}
+	return c.Connector.Close()
+}
+
+func (c *resultConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		// The boot queries run when a connection is opened.
+		collectError(endpointConnect, err)
+		return nil, err
+	}
+	duckdbConn, ok := conn.(*duckdb.Conn)
+	if !ok {
+		return conn, nil
+	}
+	openConnectionsMetric.Inc()
+	return &resultConn{Conn: duckdbConn, flattenStructs: c.flattenStructs, expandArrays: c.expandArrays, remoteRetry: c.remoteRetry}, nil
+}
+
+type resultConn struct {
+	*duckdb.Conn
+	flattenStructs bool
+	expandArrays   bool
+	remoteRetry    remoteRetry
+}
+
+func (c *resultConn) Close() error {
+	openConnectionsMetric.Dec()
+	return c.Conn.Close()
+}
+
+func (c *resultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.queryScript(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	typed, ok := rows.(typedRows)
+	if !ok {
+		return rows, nil
+	}
+	if c.flattenStructs || c.expandArrays {
+		typed = flattenRows(typed, c.flattenStructs, c.expandArrays)
+	}
+	if schema, ok := ctx.Value(resultSchemaKey{}).(*resultSchema); ok {
+		schema.record(typed)
+	}
+	if limit, ok := ctx.Value(rowLimitKey{}).(*rowLimit); ok && limit.max > 0 {
+		typed = &limitedRows{typedRows: typed, limit: limit}
+	}
+	return typed, nil
+}
+
+// queryScript runs query and returns its rows. A query with several
+// statements runs all but the last one with Exec, the arguments are bound to
+// the last one, whose rows are returned. All statements use the connection, so
+// the last one sees the temporary tables and settings of the others. Only the
+// last statement is retried, a failed script is not run again by the retries
+// of sqlds unless its other statements only change settings.
+func (c *resultConn) queryScript(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	statements := splitStatements(query)
+	if len(statements) <= 1 {
+		return c.queryStatement(ctx, query, args)
+	}
+	setup, last := statements[:len(statements)-1], statements[len(statements)-1]
+	script := func() (driver.Rows, error) {
+		for _, stmt := range setup {
+			queryLogger(ctx).Debug("Running setup statement", "sql", describeStatement(stmt))
+			if _, err := c.Conn.ExecContext(ctx, stmt, nil); err != nil {
+				return nil, err
+			}
+		}
+		return c.queryStatement(ctx, last, args)
+	}
+	if run, ok := ctx.Value(scriptRunKey{}).(*scriptRun); ok && !onlySettings(setup) {
+		return run.run(script)
+	}
+	return script()
+}
+
+// queryStatement runs a single statement, retrying the transient errors of
+// remote reads on the connection.
+func (c *resultConn) queryStatement(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	logger := queryLogger(ctx)
+	logger.Debug("Running statement", "sql", describeStatement(query), "args", len(args))
+	var rows driver.Rows
+	err := c.remoteRetry.do(ctx, func() error {
+		var err error
+		rows, err = c.Conn.QueryContext(ctx, query, args)
+		if err != nil {
+			logger.Debug("Statement failed", "error", err)
+		}
+		if attempts, ok := ctx.Value(queryAttemptsKey{}).(*queryAttempts); ok {
+			attempts.record(err)
+		}
+		return err
+	})
+	return rows, err
+}
+
+// typedRows are rows that report their column types, which the converters
+// are matched on.
+type typedRows interface {
+	driver.Rows
+	driver.RowsColumnTypeScanType
+	driver.RowsColumnTypeDatabaseTypeName
+}
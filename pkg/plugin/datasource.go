@@ -2,13 +2,20 @@ package plugin
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/grafana/sqlds/v3"
 )
@@ -21,6 +28,7 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*SQLDataSourceWrapper)(nil)
 	_ backend.CheckHealthHandler    = (*SQLDataSourceWrapper)(nil)
+	_ backend.CallResourceHandler   = (*SQLDataSourceWrapper)(nil)
 	_ instancemgmt.InstanceDisposer = (*SQLDataSourceWrapper)(nil)
 )
 
@@ -35,10 +43,33 @@ func (ds *SQLDataSourceWrapper) NewDatasource(ctx context.Context, settings back
 	}
 
 	ds.fileWatcher = NewFileWatcher(config.Path)
+	// Invalid TTLs and timeouts are reported by the driver when connecting below.
+	if ttl, _ := cacheTTL(config); ttl > 0 {
+		ds.cache = newResultCache(ttl, config.CacheMaxEntries)
+	}
+	ds.queryTimeout, _ = queryTimeout(config)
+	ds.maxQueryTimeout, _ = maxQueryTimeout(config)
+	if config.MaxConcurrentQueries > 0 {
+		ds.queryLimit = int64(config.MaxConcurrentQueries)
+		ds.querySlots = semaphore.NewWeighted(ds.queryLimit)
+	}
 
+	ds.maxRows = config.MaxRows
+	ds.queryOnly = config.QueryOnly
+	ds.multiStatements = config.MultiStatements
+	ds.useQueryTimezone = config.UseQueryTimezone
+	ds.SQLDatasource.CustomRoutes = ds.resourceRoutes()
 	newSqlDs, err := ds.SQLDatasource.NewDatasource(ctx, settings)
 	if err != nil {
-		return nil, err
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			return nil, err
+		}
+		// Keep the instance around so the health check can tell the user what
+		// is wrong with the configuration.
+		backend.Logger.Warn("Invalid datasource configuration", "error", err)
+		ds.configErr = configErr
+		return ds, nil
 	}
 	ds.SQLDatasource = newSqlDs.(*sqlds.SQLDatasource)
 
@@ -60,7 +91,7 @@ func NewFileWatcher(path string) *FileWatcher {
 
 func (f *FileWatcher) HasUpdate() bool {
 	if !f.isLocalFile {
-		backend.Logger.Debug("File watcher is not needed for non-local file (", "path=", f.path, ")")
+		backend.Logger.Debug("File watcher is not needed for non-local file", "path", f.path)
 		return false
 	}
 
@@ -82,14 +113,34 @@ func (f *FileWatcher) HasUpdate() bool {
 type SQLDataSourceWrapper struct {
 	*sqlds.SQLDatasource
 
+	driver      sqlds.Driver
 	fileWatcher *FileWatcher
+	cache       *resultCache
 	settings    backend.DataSourceInstanceSettings
+	// queryTimeout is the default timeout of a query, maxQueryTimeout the
+	// longest timeout a query may ask for.
+	queryTimeout    time.Duration
+	maxQueryTimeout time.Duration
+	// querySlots caps the number of queries running at once when set.
+	querySlots *semaphore.Weighted
+	queryLimit int64
+	// maxRows caps the rows of each query result when set.
+	maxRows int64
+	// queryOnly rejects statements that are not queries.
+	queryOnly bool
+	// multiStatements allows queries with several statements.
+	multiStatements bool
+	// useQueryTimezone applies the timezone sent with the queries.
+	useQueryTimezone bool
+	// configErr is set when the settings are invalid, all requests fail with it.
+	configErr *ConfigError
 }
 
 // NewDatasource initializes the Datasource wrapper and instance manager
 func NewDatasource(c sqlds.Driver) *SQLDataSourceWrapper {
 	return &SQLDataSourceWrapper{
 		SQLDatasource: sqlds.NewDatasource(c),
+		driver:        c,
 	}
 }
 
@@ -99,8 +150,18 @@ func NewDatasource(c sqlds.Driver) *SQLDataSourceWrapper {
 func (d *SQLDataSourceWrapper) Dispose() {
 
 	d.SQLDatasource.Dispose()
+	// sqlds leaves the connections open. Closing them hands a shared
+	// connector back, so a replacement can open the file in another mode.
+	if db, err := d.defaultDB(context.Background()); err == nil {
+		if err := db.Close(); err != nil {
+			backend.Logger.Warn("Failed to close the database", "error", err)
+		}
+	}
 
 	// Clean up SQLDataSourceWrapper instance resources.
+	if d.cache != nil {
+		d.cache.Purge()
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -108,24 +169,517 @@ func (d *SQLDataSourceWrapper) Dispose() {
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
 // contains Frames ([]*Frame).
 func (d *SQLDataSourceWrapper) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	if d.configErr != nil {
+		response := backend.NewQueryDataResponse()
+		for _, query := range req.Queries {
+			collectError(endpointQuery, d.configErr)
+			response.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, d.configErr.Error())
+		}
+		return response, nil
+	}
+
 	if d.fileWatcher.HasUpdate() {
 		backend.Logger.Debug("DuckDB file has been modified, reloading DataSource.")
+		// Hand the shared connector back first, the file is only opened again
+		// when no other instance holds it.
+		if db, err := d.defaultDB(ctx); err == nil {
+			if err := db.Close(); err != nil {
+				backend.Logger.Warn("Failed to close the database", "error", err)
+			}
+		}
 		newSqlDs, err := d.SQLDatasource.NewDatasource(ctx, d.settings)
 		if err != nil {
+			// The database is closed, so the next request tries again.
+			d.fileWatcher.lastModified = time.Time{}
 			return nil, err
 		}
 		d.SQLDatasource = newSqlDs.(*sqlds.SQLDatasource)
+		if d.cache != nil {
+			d.cache.Purge()
+		}
+	}
+
+	// Rejected queries are answered without running the others of the request.
+	rejected := backend.NewQueryDataResponse()
+	if d.queryOnly {
+		req = rejectQueries(req, rejected, checkQueryOnlyModel)
+	}
+	// Checked before the timezone and user statements are added.
+	if !d.multiStatements {
+		req = rejectQueries(req, rejected, checkSingleStatementModel)
+	}
+
+	if d.useQueryTimezone {
+		var err error
+		if req, err = withQueryTimezone(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.DriverSettings().ForwardHeaders {
+		var err error
+		if req, err = withGrafanaUser(req); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		response *backend.QueryDataResponse
+		err      error
+	)
+	if d.cache != nil {
+		response, err = d.queryDataCached(ctx, req)
+	} else {
+		response, err = d.runQueries(ctx, req)
+	}
+	if response != nil {
+		for refID, r := range rejected.Responses {
+			response.Responses[refID] = r
+		}
+	}
+
+	return response, err
+}
+
+// rejectQueries answers the queries of req that fail check in rejected and
+// returns the request with the remaining queries.
+func rejectQueries(req *backend.QueryDataRequest, rejected *backend.QueryDataResponse, check func(backend.DataQuery) error) *backend.QueryDataRequest {
+	queries := []backend.DataQuery{}
+	for _, query := range req.Queries {
+		if err := check(query); err != nil {
+			collectError(endpointQuery, err)
+			rejected.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		queries = append(queries, query)
+	}
+	if len(queries) == len(req.Queries) {
+		return req
+	}
+	filtered := *req
+	filtered.Queries = queries
+	return &filtered
+}
+
+// runQueries sends the queries to sqlds one by one, each with its own timeout.
+// With maxConcurrentQueries set, each query first waits for a free slot for up
+// to its timeout.
+func (d *SQLDataSourceWrapper) runQueries(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		errs     error
+		response = backend.NewQueryDataResponse()
+	)
+	for _, query := range req.Queries {
+		wg.Add(1)
+		go func(query backend.DataQuery) {
+			defer wg.Done()
+
+			res, err := d.runQuery(ctx, req, query)
+			mu.Lock()
+			defer mu.Unlock()
+			errs = errors.Join(errs, err)
+			if res == nil {
+				return
+			}
+			for refID, r := range res.Responses {
+				response.Responses[refID] = r
+			}
+		}(query)
+	}
+	wg.Wait()
+
+	return response, errs
+}
+
+// runQuery runs a single query and records its metrics. Its messages are
+// logged with a query ID of their own.
+func (d *SQLDataSourceWrapper) runQuery(ctx context.Context, req *backend.QueryDataRequest, query backend.DataQuery) (*backend.QueryDataResponse, error) {
+	ctx, _ = withQueryID(ctx)
+	logger := queryLogger(ctx)
+	logger.Debug("Query started", "refId", query.RefID)
+
+	start := time.Now()
+	res, err := d.executeQuery(ctx, req, query)
+	queryErr := err
+	if queryErr == nil && res != nil {
+		queryErr = res.Responses[query.RefID].Error
+	}
+	collectQuery(start, queryErr)
+	if queryErr != nil {
+		logger.Warn("Query failed", "refId", query.RefID, "duration", time.Since(start), "error", queryErr)
+	} else {
+		logger.Debug("Query finished", "refId", query.RefID, "duration", time.Since(start))
+	}
+	return res, err
+}
+
+func (d *SQLDataSourceWrapper) executeQuery(ctx context.Context, req *backend.QueryDataRequest, query backend.DataQuery) (*backend.QueryDataResponse, error) {
+	timeout, err := d.timeoutFor(query)
+	if err != nil {
+		res := backend.NewQueryDataResponse()
+		res.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		return res, nil
+	}
+	params, err := queryParams(query)
+	if err != nil {
+		res := backend.NewQueryDataResponse()
+		res.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		return res, nil
+	}
+	withTimeout := func(ctx context.Context) (context.Context, context.CancelFunc) {
+		if timeout <= 0 {
+			return context.WithCancel(ctx)
+		}
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	if d.querySlots != nil {
+		acquireCtx, cancel := withTimeout(ctx)
+		err := d.querySlots.Acquire(acquireCtx, 1)
+		cancel()
+		if err != nil {
+			res := backend.NewQueryDataResponse()
+			if ctx.Err() != nil {
+				// The request was cancelled while waiting, no slot was missing.
+				res.Responses[query.RefID] = backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+					"query cancelled while waiting for a query slot: "+ctx.Err().Error())
+				return res, nil
+			}
+			res.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusTooManyRequests,
+				fmt.Sprintf("too many concurrent queries: no query slot out of %d became free within %s", d.queryLimit, timeout))
+			return res, nil
+		}
+		defer d.querySlots.Release(1)
+	}
+
+	annotation := query.QueryType == annotationQueryType
+	pivotBy := queryPivotBy(query)
+	if annotation || pivotBy != "" {
+		// Annotations and pivoted series are built from the rows, never from a
+		// time series sqlds reshaped.
+		if query, err = withModelField(query, "format", sqlutil.FormatOptionTable); err != nil {
+			res := backend.NewQueryDataResponse()
+			res.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			return res, nil
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var limit *rowLimit
+	if d.maxRows > 0 {
+		ctx, limit = withRowLimit(ctx, d.maxRows)
+	}
+	ctx, attempts := withQueryAttempts(ctx)
+	ctx = withScriptRun(ctx)
+	ctx, schema := withResultSchema(ctx)
+	if len(params) > 0 {
+		ctx = withQueryParams(ctx, params)
+	}
+	single := *req
+	single.Queries = []backend.DataQuery{query}
+	res, err := d.SQLDatasource.QueryData(ctx, &single)
+	if err != nil {
+		return res, err
+	}
+	res.Responses[query.RefID] = withEmptyFrame(res.Responses[query.RefID], schema, d.driver.Converters())
+	if annotation {
+		res.Responses[query.RefID] = annotationResponse(res.Responses[query.RefID])
+	} else if pivotBy != "" {
+		res.Responses[query.RefID] = pivotResponse(res.Responses[query.RefID], pivotBy)
+	}
+	if retries, lastErr := attempts.retries(); retries > 0 {
+		res.Responses[query.RefID] = markRetried(res.Responses[query.RefID], retries, lastErr)
+	}
+	if limit != nil && limit.truncated.Load() {
+		res.Responses[query.RefID] = markTruncated(res.Responses[query.RefID], limit.max)
+	}
+	return res, nil
+}
+
+// withModelField returns a copy of query with key set to value in its model.
+func withModelField(query backend.DataQuery, key string, value any) (backend.DataQuery, error) {
+	var model map[string]any
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		return query, err
+	}
+	model[key] = value
+	raw, err := json.Marshal(model)
+	if err != nil {
+		return query, err
+	}
+	query.JSON = raw
+	return query, nil
+}
+
+// timeoutFor returns the timeout of a query. A query may ask for its own
+// timeout with a queryTimeout field in its model, which is capped at the
+// maxQueryTimeout setting, or at the datasource timeout when that is not set.
+func (d *SQLDataSourceWrapper) timeoutFor(query backend.DataQuery) (time.Duration, error) {
+	var model struct {
+		QueryTimeout string `json:"queryTimeout"`
+	}
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		// Leave reporting the invalid model to sqlds.
+		return d.queryTimeout, nil
+	}
+	value := strings.TrimSpace(model.QueryTimeout)
+	if value == "" {
+		return d.queryTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid query timeout %q: expected a duration like 5m", value)
+	}
+	if limit := max(d.queryTimeout, d.maxQueryTimeout); limit > 0 && timeout > limit {
+		backend.Logger.Debug("Query timeout capped", "requested", timeout, "limit", limit)
+		timeout = limit
+	}
+	return timeout, nil
+}
+
+// grafanaUserHeader is set by Grafana when send_user_header is enabled.
+const grafanaUserHeader = "X-Grafana-User"
+
+// withGrafanaUser prefixes the queries with a statement storing the querying
+// Grafana user in the grafana_user variable, so it can be read in audit logs
+// with getvariable('grafana_user'). The user is taken from the X-Grafana-User
+// header, falling back to the plugin context. Running both statements in one
+// call makes sure they use the same connection.
+func withGrafanaUser(req *backend.QueryDataRequest) (*backend.QueryDataRequest, error) {
+	user := req.GetHTTPHeader(grafanaUserHeader)
+	if user == "" && req.PluginContext.User != nil {
+		user = req.PluginContext.User.Login
 	}
+	if user == "" {
+		return req, nil
+	}
+
+	queries := make([]backend.DataQuery, len(req.Queries))
+	for i, query := range req.Queries {
+		var model map[string]any
+		if err := json.Unmarshal(query.JSON, &model); err != nil {
+			return nil, err
+		}
+		if rawSQL, ok := model["rawSql"].(string); ok && strings.TrimSpace(rawSQL) != "" {
+			model["rawSql"] = "SET VARIABLE grafana_user = " + quoteLiteral(user) + ";\n" + rawSQL
+			raw, err := json.Marshal(model)
+			if err != nil {
+				return nil, err
+			}
+			query.JSON = raw
+		}
+		queries[i] = query
+	}
+
+	mutated := *req
+	mutated.Queries = queries
+	return &mutated, nil
+}
 
-	response, err := d.SQLDatasource.QueryData(ctx, req)
+// queryDataCached serves queries from the result cache when possible and only
+// sends the misses to DuckDB. Successful responses are stored for later requests.
+func (d *SQLDataSourceWrapper) queryDataCached(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	response := backend.NewQueryDataResponse()
+	keys := make(map[string]string)
+	misses := []backend.DataQuery{}
+
+	for _, query := range req.Queries {
+		key, ok := d.cacheKey(req, query)
+		if !ok {
+			misses = append(misses, query)
+			continue
+		}
+		if frames, hit := d.cache.Get(key); hit {
+			response.Responses[query.RefID] = backend.DataResponse{Frames: frames}
+			continue
+		}
+		keys[query.RefID] = key
+		misses = append(misses, query)
+	}
+
+	if len(misses) == 0 {
+		return response, nil
+	}
+
+	missReq := *req
+	missReq.Queries = misses
+	res, err := d.runQueries(ctx, &missReq)
+	if res == nil {
+		return nil, err
+	}
+	for refID, r := range res.Responses {
+		response.Responses[refID] = r
+		if key, ok := keys[refID]; ok && r.Error == nil {
+			d.cache.Set(key, r.Frames)
+		}
+	}
 
 	return response, err
 }
 
+// cacheKey interpolates the query macros the same way sqlds does and derives the
+// cache key from the result, the query type, the pivot and the query
+// parameters. Queries that fail to parse are never cached.
+func (d *SQLDataSourceWrapper) cacheKey(req *backend.QueryDataRequest, query backend.DataQuery) (string, bool) {
+	q, err := sqlds.GetQuery(query, req.GetHTTPHeaders(), d.DriverSettings().ForwardHeaders)
+	if err != nil {
+		return "", false
+	}
+	q.RawSQL, err = sqlds.Interpolate(d.driver, q)
+	if err != nil {
+		return "", false
+	}
+	params, err := queryParams(query)
+	if err != nil {
+		return "", false
+	}
+	// The query type and the pivot reshape the frames after the query ran, so
+	// they belong to the key as much as the SQL does.
+	key := d.cache.Key(q) + "\x00" + query.QueryType + "\x00" + queryPivotBy(query)
+	if len(params) > 0 {
+		// Marshalling keeps the types apart, 1 and "1" are different parameters.
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return "", false
+		}
+		key += "\x00" + string(raw)
+	}
+	return key, true
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // SQLDataSourceWrapper configuration page which allows users to verify that
 // a SQLDataSourceWrapper is working as expected.
+//
+// It runs a probe query against the database and, for MotherDuck, checks that
+// the database was attached. Configuration errors (like a missing token or an
+// invalid path) are shown to the user, other errors are only logged.
 func (d *SQLDataSourceWrapper) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	return d.SQLDatasource.CheckHealth(ctx, req)
+	if d.configErr != nil {
+		return healthError(d.configErr), nil
+	}
+	config, err := models.LoadPluginSettings(d.settings)
+	if err != nil {
+		return healthError(err), nil
+	}
+	if err := resolveMotherDuckToken(config); err != nil {
+		return healthError(err), nil
+	}
+	// The database file may have gone away since the datasource was created.
+	if err := ValidateConfig(config); err != nil {
+		return healthError(err), nil
+	}
+
+	if timeout := d.queryTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	db, err := d.defaultDB(ctx)
+	if err != nil {
+		return healthError(err), nil
+	}
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return healthError(err), nil
+	}
+
+	if name := motherDuckDatabase(config.Path); name != "" {
+		if alias := strings.TrimSpace(config.MotherDuckAlias); alias != "" {
+			name = alias
+		}
+		var attached int
+		err := db.QueryRowContext(ctx, "SELECT count(*) FROM duckdb_databases() WHERE database_name = ?", name).Scan(&attached)
+		if err != nil {
+			return healthError(err), nil
+		}
+		if attached == 0 {
+			return healthError(&ConfigError{fmt.Sprintf("MotherDuck database %s is not attached, check the database name in the path", name)}), nil
+		}
+	}
+
+	info, err := getDuckDBInfo(ctx, db)
+	if err != nil {
+		return healthError(err), nil
+	}
+	details, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	message := "Data source is working. DuckDB " + info.Version
+	if len(info.Extensions) > 0 {
+		message += ", extensions: " + strings.Join(info.Extensions, ", ")
+	}
+	return &backend.CheckHealthResult{
+		Status:      backend.HealthStatusOk,
+		Message:     message,
+		JSONDetails: details,
+	}, nil
+}
+
+// duckDBInfo is reported by the health check to make bug reports actionable.
+type duckDBInfo struct {
+	Version    string   `json:"version"`
+	Extensions []string `json:"extensions"`
+}
+
+func getDuckDBInfo(ctx context.Context, db *sql.DB) (*duckDBInfo, error) {
+	info := &duckDBInfo{Extensions: []string{}}
+	if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&info.Version); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT extension_name FROM duckdb_extensions() WHERE loaded ORDER BY extension_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		info.Extensions = append(info.Extensions, name)
+	}
+	return info, rows.Err()
+}
+
+// healthError turns err into a failed health check. Configuration errors are
+// fixable by the user and shown as is. Other errors may expose internals like
+// file paths or driver messages, so they are logged and replaced with a generic
+// message.
+func healthError(err error) *backend.CheckHealthResult {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: "Configuration error: " + configErr.Error(),
+		}
+	}
+	backend.Logger.Error("Health check failed", "error", err)
+	return &backend.CheckHealthResult{
+		Status:  backend.HealthStatusError,
+		Message: "Internal error, see the Grafana server logs for details",
+	}
+}
+
+// motherDuckDatabase returns the name of the database attached for a md: path,
+// or an empty string when the path does not name a single MotherDuck database.
+func motherDuckDatabase(path string) string {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "md:") {
+		return ""
+	}
+	path, err := normalizeMotherDuckPath(path)
+	if err != nil {
+		return ""
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(path, "md:"), "?")
+	return name
 }
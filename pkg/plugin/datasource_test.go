@@ -2,12 +2,21 @@ package plugin
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/sqlds/v3"
 )
 
 func TestQueryData(t *testing.T) {
@@ -130,3 +139,483 @@ func TestMultipleQueriesRequest(t *testing.T) {
 		}
 	}
 }
+
+// runDataQuery runs a single query with RefID "A" and returns its response.
+func runDataQuery(t *testing.T, ds *SQLDataSourceWrapper, query backend.DataQuery) backend.DataResponse {
+	t.Helper()
+	query.RefID = "A"
+	resp, err := ds.QueryData(
+		context.Background(),
+		&backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{},
+			},
+			Queries: []backend.DataQuery{query},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Responses["A"]
+}
+
+// runQuery runs rawSQL as a table query.
+func runQuery(t *testing.T, ds *SQLDataSourceWrapper, rawSQL string) backend.DataResponse {
+	t.Helper()
+	model, err := json.Marshal(map[string]any{"rawSql": rawSQL, "format": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runDataQuery(t, ds, backend.DataQuery{JSON: model})
+}
+
+func queryRandom(t *testing.T, ds *SQLDataSourceWrapper, timeRange backend.TimeRange) interface{} {
+	t.Helper()
+	r := runDataQuery(t, ds, backend.DataQuery{TimeRange: timeRange, JSON: json.RawMessage(`{"rawSql": "SELECT random() AS r", "format": 1}`)})
+	if r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	return r.Frames[0].Fields[0].At(0)
+}
+
+func TestQueryDataCache(t *testing.T) {
+	now := time.Now()
+	lastHour := backend.TimeRange{From: now.Add(-time.Hour), To: now}
+	lastDay := backend.TimeRange{From: now.Add(-24 * time.Hour), To: now}
+
+	ds := newTestDatasource(t, `{"path":"", "cacheTtlSeconds": 3600}`)
+
+	first := queryRandom(t, ds, lastHour)
+	if cached := queryRandom(t, ds, lastHour); !reflect.DeepEqual(first, cached) {
+		t.Errorf("expected cached result %v, got %v", first, cached)
+	}
+	if other := queryRandom(t, ds, lastDay); reflect.DeepEqual(first, other) {
+		t.Errorf("expected a different time range to miss the cache")
+	}
+	if ds.cache.Len() != 2 {
+		t.Errorf("expected 2 cache entries, got %d", ds.cache.Len())
+	}
+}
+
+func TestQueryDataCacheTTL(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "cacheTTL": "1m", "cacheTtlSeconds": 3600}`)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ds.cache.now = func() time.Time { return now }
+
+	query := func(rawSQL string, timeRange backend.TimeRange) any {
+		t.Helper()
+		model, err := json.Marshal(map[string]any{"rawSql": rawSQL, "format": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := runDataQuery(t, ds, backend.DataQuery{TimeRange: timeRange, JSON: model})
+		if r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		return r.Frames[0].Fields[0].At(0)
+	}
+	// The time range only reaches the key through the expanded macro.
+	rawSQL := "SELECT random() AS r WHERE $__timeFrom() IS NOT NULL"
+	lastHour := backend.TimeRange{From: now.Add(-time.Hour), To: now}
+	shifted := backend.TimeRange{From: now.Add(-time.Hour + time.Second), To: now.Add(time.Second)}
+
+	first := query(rawSQL, lastHour)
+	if cached := query("SELECT  random() AS r\n  WHERE $__timeFrom() IS NOT NULL;", lastHour); !reflect.DeepEqual(first, cached) {
+		t.Errorf("expected the reformatted query to hit the cache, got %v and %v", first, cached)
+	}
+	if other := query(rawSQL, shifted); reflect.DeepEqual(first, other) {
+		t.Error("expected a different time range to miss the cache")
+	}
+
+	// cacheTTL takes precedence over cacheTtlSeconds.
+	now = now.Add(time.Minute)
+	if expired := query(rawSQL, lastHour); reflect.DeepEqual(first, expired) {
+		t.Error("expected the result to expire after the TTL")
+	}
+}
+
+func TestQueryDataCacheDisabledByDefault(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":""}`)
+	if ds.cache != nil {
+		t.Fatal("expected the result cache to be disabled")
+	}
+
+	timeRange := backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()}
+	if first, second := queryRandom(t, ds, timeRange), queryRandom(t, ds, timeRange); reflect.DeepEqual(first, second) {
+		t.Errorf("expected uncached queries to run twice, got %v both times", first)
+	}
+}
+
+func TestInitSqlContinueOnError(t *testing.T) {
+	initSql := "CREATE VIEW broken AS SELECT * FROM missing_table; CREATE TABLE ok AS SELECT 42 AS x;"
+
+	ds := newTestDatasource(t, fmt.Sprintf(`{"path":"", "initSql": %q}`, initSql))
+	if r := runQuery(t, ds, "SELECT 1"); r.Error == nil {
+		t.Fatal("expected a failing init statement to fail the connection by default")
+	}
+
+	ds = newTestDatasource(t, fmt.Sprintf(`{"path":"", "initSql": %q, "initSqlContinueOnError": true}`, initSql))
+	if r := runQuery(t, ds, "SELECT x FROM ok"); r.Error != nil {
+		t.Fatalf("expected the statement after the failing one to run: %v", r.Error)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonData string
+		status   backend.HealthStatus
+		message  string
+	}{
+		{name: "in-memory", jsonData: `{"path": ""}`, status: backend.HealthStatusOk, message: "Data source is working"},
+		{name: "missing token", jsonData: `{"path": "md:my_db"}`, status: backend.HealthStatusError, message: "Configuration error: MotherDuck Token is missing"},
+		{name: "quoted path", jsonData: `{"path": "'md:my_db'"}`, status: backend.HealthStatusError, message: "Configuration error: Invalid path"},
+		{name: "failing init sql", jsonData: `{"path": "", "initSql": "SELECT * FROM secret_table"}`, status: backend.HealthStatusError, message: "Internal error, see the Grafana server logs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDatasource(&DuckDBDriver{Initialized: false})
+			_, err := ds.NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+				JSONData: []byte(tt.jsonData),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.status || !strings.HasPrefix(res.Message, tt.message) {
+				t.Errorf("expected %v %q, got %v %q", tt.status, tt.message, res.Status, res.Message)
+			}
+		})
+	}
+}
+
+func TestHealthError(t *testing.T) {
+	res := healthError(sqlds.DownstreamError(&ConfigError{"MotherDuck Token is missing for motherduck connection"}))
+	if res.Status != backend.HealthStatusError || res.Message != "Configuration error: MotherDuck Token is missing for motherduck connection" {
+		t.Errorf("expected the configuration error verbatim, got %v %q", res.Status, res.Message)
+	}
+
+	res = healthError(errors.New("IO Error: Cannot open file /var/lib/grafana/private.duckdb"))
+	if res.Status != backend.HealthStatusError || res.Message != "Internal error, see the Grafana server logs for details" {
+		t.Errorf("expected a generic error, got %v %q", res.Status, res.Message)
+	}
+}
+
+func TestConfigErrorFailsQueries(t *testing.T) {
+	ds := NewDatasource(&DuckDBDriver{Initialized: false})
+	_, err := ds.NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": "md:my_db"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := runQuery(t, ds, "SELECT 1")
+	if res.Error == nil || res.Status != backend.StatusBadRequest {
+		t.Errorf("expected a bad request error, got %v %v", res.Status, res.Error)
+	}
+}
+
+func TestMotherDuckDatabase(t *testing.T) {
+	for path, expected := range map[string]string{
+		"md:my_db":                "my_db",
+		"md:my_db?saas_mode=true": "my_db",
+		"md:":                     "",
+		"/data/test.duckdb":       "",
+	} {
+		if got := motherDuckDatabase(path); got != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, got)
+		}
+	}
+}
+
+func TestForwardHeadersGrafanaUser(t *testing.T) {
+	query := backend.DataQuery{RefID: "A", JSON: json.RawMessage(`{"rawSql": "SELECT getvariable('grafana_user')::VARCHAR AS u", "format": 1}`)}
+	queryUser := func(ds *SQLDataSourceWrapper, req *backend.QueryDataRequest) any {
+		t.Helper()
+		req.Queries = []backend.DataQuery{query}
+		resp, err := ds.QueryData(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := resp.Responses["A"]
+		if r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		return r.Frames[0].Fields[0].At(0)
+	}
+
+	ds := newTestDatasource(t, `{"path": "", "forwardHeaders": true}`)
+
+	req := &backend.QueryDataRequest{}
+	req.SetHTTPHeader("X-Grafana-User", "o'brien; DROP TABLE x; --")
+	if got := queryUser(ds, req); got == nil || *got.(*string) != "o'brien; DROP TABLE x; --" {
+		t.Errorf("expected the user from the header, got %v", got)
+	}
+
+	req = &backend.QueryDataRequest{PluginContext: backend.PluginContext{User: &backend.User{Login: "admin"}}}
+	if got := queryUser(ds, req); got == nil || *got.(*string) != "admin" {
+		t.Errorf("expected the user from the plugin context, got %v", got)
+	}
+
+	// Without forwardHeaders the variable is never set.
+	ds = newTestDatasource(t, `{"path": ""}`)
+	req = &backend.QueryDataRequest{}
+	req.SetHTTPHeader("X-Grafana-User", "admin")
+	if got := queryUser(ds, req); got.(*string) != nil {
+		t.Errorf("expected no user, got %v", *got.(*string))
+	}
+}
+
+func TestCheckHealthReportsVersion(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Fatalf("expected the health check to pass, got %q", res.Message)
+	}
+
+	var info duckDBInfo
+	if err := json.Unmarshal(res.JSONDetails, &info); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(info.Version, "v") || !strings.Contains(res.Message, "DuckDB "+info.Version) {
+		t.Errorf("expected the DuckDB version in the health message, got %q (details %s)", res.Message, res.JSONDetails)
+	}
+	if info.Extensions == nil {
+		t.Error("expected the list of loaded extensions")
+	}
+}
+
+func TestMaxConcurrentQueries(t *testing.T) {
+	// The converter runs while the query is being read, so it sees how many
+	// queries are in flight at the same time.
+	var inFlight, maxInFlight atomic.Int32
+	slow := sqlutil.Converter{
+		Name:          "slow VARCHAR",
+		InputScanType: reflect.TypeOf(sql.NullString{}),
+		InputTypeName: "VARCHAR",
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableString,
+			ConverterFunc: func(in interface{}) (interface{}, error) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				v := in.(*sql.NullString)
+				return &v.String, nil
+			},
+		},
+	}
+
+	ds := NewDatasource(&DuckDBDriver{CustomConverters: []sqlutil.Converter{slow}})
+	_, err := ds.NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": "", "maxConcurrentQueries": 2}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &backend.QueryDataRequest{}
+	for i := 0; i < 8; i++ {
+		req.Queries = append(req.Queries, backend.DataQuery{
+			RefID: fmt.Sprintf("Q%d", i),
+			JSON:  json.RawMessage(`{"rawSql": "SELECT 'x' AS s", "format": 1}`),
+		})
+	}
+	resp, err := ds.QueryData(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for refID, r := range resp.Responses {
+		if r.Error != nil {
+			t.Errorf("%s: %v", refID, r.Error)
+		}
+	}
+	if len(resp.Responses) != 8 {
+		t.Errorf("expected 8 responses, got %d", len(resp.Responses))
+	}
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("expected at most 2 queries in flight, got %d", got)
+	}
+}
+
+func TestMaxConcurrentQueriesTimeout(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "maxConcurrentQueries": 1, "queryTimeout": "50ms"}`)
+
+	// Take the only slot so that the next query has to wait for it.
+	if err := ds.querySlots.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	res := runQuery(t, ds, "SELECT 1")
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "too many concurrent queries") {
+		t.Fatalf("expected a concurrency error, got %v", res.Error)
+	}
+	if res.Status != backend.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", backend.StatusTooManyRequests, res.Status)
+	}
+
+	ds.querySlots.Release(1)
+	if res := runQuery(t, ds, "SELECT 1"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+}
+
+func TestQueryTimeoutOverride(t *testing.T) {
+	query := func(timeout string) backend.DataQuery {
+		return backend.DataQuery{RefID: "A", JSON: json.RawMessage(`{"rawSql": "SELECT 1", "queryTimeout": "` + timeout + `"}`)}
+	}
+
+	ds := newTestDatasource(t, `{"path": "", "queryTimeout": "1m", "maxQueryTimeout": "10m"}`)
+	tests := []struct {
+		timeout  string
+		expected time.Duration
+	}{
+		{"", time.Minute},
+		{"5s", 5 * time.Second},
+		{"5m", 5 * time.Minute},
+		{"1h", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := ds.timeoutFor(query(tt.timeout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.timeout, tt.expected, got)
+		}
+	}
+
+	// Without maxQueryTimeout a query can only shorten the timeout.
+	ds = newTestDatasource(t, `{"path": "", "queryTimeout": "1m"}`)
+	if got, _ := ds.timeoutFor(query("5m")); got != time.Minute {
+		t.Errorf("expected the timeout to be capped at 1m, got %v", got)
+	}
+
+	res := runDataQuery(t, ds, query("soon"))
+	if res.Error == nil || res.Status != backend.StatusBadRequest || !strings.Contains(res.Error.Error(), `invalid query timeout "soon"`) {
+		t.Errorf("expected an invalid timeout error, got %v %v", res.Status, res.Error)
+	}
+}
+
+func TestQueryTimeoutOverrideApplied(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "retries": 0}`)
+
+	start := time.Now()
+	res := runDataQuery(t, ds, backend.DataQuery{RefID: "A", JSON: json.RawMessage(
+		`{"rawSql": "SELECT count(*) FROM range(1000000000000) a", "format": 1, "queryTimeout": "100ms"}`,
+	)})
+	if res.Error == nil {
+		t.Fatal("expected the query to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the query to be cancelled after 100ms, took %v", elapsed)
+	}
+}
+
+func TestEmptyResultKeepsSchema(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+	now := time.Now()
+
+	for name, format := range map[string]int{"time series": 0, "table": 1, "multi": 4} {
+		t.Run(name, func(t *testing.T) {
+			model, err := json.Marshal(map[string]any{"rawSql": "SELECT now() AS time, 1::INTEGER AS x, 'a' AS metric WHERE false", "format": format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := runDataQuery(t, ds, backend.DataQuery{JSON: model, TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now}})
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if len(res.Frames) != 1 {
+				t.Fatalf("expected 1 frame, got %d", len(res.Frames))
+			}
+			frame := res.Frames[0]
+			if frame.Rows() != 0 {
+				t.Errorf("expected no rows, got %d", frame.Rows())
+			}
+			expected := []data.FieldType{data.FieldTypeNullableTime, data.FieldTypeNullableInt32, data.FieldTypeNullableString}
+			if len(frame.Fields) != len(expected) {
+				t.Fatalf("expected %d fields, got %d", len(expected), len(frame.Fields))
+			}
+			for i, field := range frame.Fields {
+				if field.Type() != expected[i] {
+					t.Errorf("expected field %s to be %s, got %s", field.Name, expected[i], field.Type())
+				}
+			}
+		})
+	}
+
+	res := runQuery(t, ds, "SELECT 1::INTEGER AS x WHERE false")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if fields := res.Frames[0].Fields; len(fields) != 1 || fields[0].Name != "x" || fields[0].Type() != data.FieldTypeNullableInt32 {
+		t.Errorf("expected an Int32 field x, got %v", fields)
+	}
+}
+
+func TestCancelledRequestInterruptsQuery(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "queryTimeout": "10m"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	// The recursive CTE runs until it is interrupted.
+	start := time.Now()
+	resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{}},
+		Queries: []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(
+			`{"rawSql": "WITH RECURSIVE r(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM r) SELECT count(*) FROM r", "format": 1}`,
+		)}},
+	})
+	elapsed := time.Since(start)
+	if err == nil && resp.Responses["A"].Error == nil {
+		t.Fatal("expected the cancelled query to fail")
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("expected the query to be interrupted after 200ms, took %v", elapsed)
+	}
+
+	// The connection is usable after the interrupt.
+	if res := runQuery(t, ds, "SELECT 1"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+}
+
+func TestCancelledRequestWaitingForQuerySlot(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "maxConcurrentQueries": 1, "queryTimeout": "10m"}`)
+	if err := ds.querySlots.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer ds.querySlots.Release(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{}},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(`{"rawSql": "SELECT 1", "format": 1}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := resp.Responses["A"]
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "query cancelled while waiting for a query slot") {
+		t.Fatalf("expected a cancellation error, got %v", res.Error)
+	}
+	if res.Status == backend.StatusTooManyRequests {
+		t.Error("expected a cancelled request not to be reported as too many queries")
+	}
+}
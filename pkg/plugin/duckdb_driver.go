@@ -4,18 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/mitchellh/mapstructure"
 
@@ -36,8 +41,28 @@ func (e *ConfigError) Error() string {
 }
 
 type DuckDBDriver struct {
-	mu          sync.Mutex
+	mu sync.Mutex
+	// Initialized is set once the boot queries ran on a database.
 	Initialized bool
+	// CustomConverters are appended to the built-in converters, e.g. for types
+	// returned by user defined functions. The built-in converters take
+	// precedence for the types they handle.
+	CustomConverters []sqlutil.Converter
+	// converterOptions are taken from the settings on Connect.
+	converterOptions converterOptions
+}
+
+// converterOptions change how some DuckDB types are converted to fields.
+type converterOptions struct {
+	hugeIntAsFloat  bool
+	decimalAsString bool
+}
+
+func converterOptionsFromSettings(config *models.PluginSettings) converterOptions {
+	return converterOptions{
+		hugeIntAsFloat:  config.HugeIntAsFloat,
+		decimalAsString: config.DecimalAsString,
+	}
 }
 
 // parse config from settings.JSONData
@@ -51,135 +76,1161 @@ func parseConfig(settings backend.DataSourceInstanceSettings) (map[string]string
 }
 
 func (d *DuckDBDriver) Connect(ctx context.Context, settings backend.DataSourceInstanceSettings, msg json.RawMessage) (*sql.DB, error) {
+	db, err := d.connect(ctx, settings)
+	if err != nil {
+		collectError(endpointConnect, err)
+	}
+	return db, err
+}
+
+func (d *DuckDBDriver) connect(ctx context.Context, settings backend.DataSourceInstanceSettings) (*sql.DB, error) {
 	config, err := models.LoadPluginSettings(settings)
 	if err != nil {
 		return nil, err
 	}
 
-	// Determine connector path based on input
-	var path string
-	trimmedPath := strings.TrimSpace(config.Path)
-
-	// Check for invalid path with quotes
-	cleanPath := trimmedPath
-	if (strings.HasPrefix(trimmedPath, "'") && strings.HasSuffix(trimmedPath, "'")) ||
-		(strings.HasPrefix(trimmedPath, "\"") && strings.HasSuffix(trimmedPath, "\"")) {
-		return nil, &ConfigError{"Invalid path: " + trimmedPath + " -> example input: md:sample_data"}
+	if err := resolveMotherDuckToken(config); err != nil {
+		return nil, err
+	}
+	if err := ValidateConfig(config); err != nil {
+		return nil, err
 	}
 
-	if strings.HasPrefix(cleanPath, "md:") {
+	// ValidateConfig checked the mode already.
+	mode, _ := databaseMode(config)
+	explicit := strings.TrimSpace(config.Mode) != ""
+	backend.Logger.Info("Selected database mode", "mode", mode, "explicit", explicit)
+
+	// Determine connector path based on the mode
+	var path string
+	switch mode {
+	case modeMotherDuck:
 		// MotherDuck: use in-memory base and ATTACH later
-		if config.Secrets.MotherDuckToken == "" {
-			return nil, &ConfigError{"MotherDuck Token is missing for motherduck connection"}
-		}
 		path = ""
-	} else if trimmedPath != "" {
+	case modeFile:
 		// Local file: use the path directly as connector path
-		path = trimmedPath
-		backend.Logger.Info("Local file path is: " + path)
-	} else {
-		// Empty: in-memory database
+		path = strings.TrimSpace(config.Path)
+		backend.Logger.Info("Opening local database file", "path", path)
+	default:
+		// In-memory database, nothing is persisted
 		path = ""
+		if !explicit {
+			backend.Logger.Warn("The path is empty, opening an in-memory database whose data is lost on restart. Set the mode to memory to make this explicit")
+		}
+	}
+	ds, err := driverSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	timeout := ds.Timeout
+	queries, err := bootQueries(config)
+	if err != nil {
+		return nil, err
+	}
+	settingQueries, err := settingQueries(config)
+	if err != nil {
+		return nil, err
+	}
+	searchPath, err := searchPathQuery(config)
+	if err != nil {
+		return nil, err
+	}
+	remoteRetry, err := remoteRetryPolicy(config)
+	if err != nil {
+		return nil, err
 	}
-	// connect with the path before any other queries are run.
-	connector, err := duckdb.NewConnector(path, func(execer driver.ExecerContext) error {
-		d.mu.Lock()
-		defer d.mu.Unlock()
-		bootQueries := []string{}
-		if !d.Initialized {
-			// read env variable GF_PATHS_DATA and use it as the home directory for extension installation.
-			homePath := os.Getenv("GF_PATHS_DATA")
-
-			if homePath != "" {
-				bootQueries = append(bootQueries, "SET home_directory='"+homePath+"';")
-				extensionPath := filepath.Join(homePath, ".duckdb/extensions")
-				bootQueries = append(bootQueries, "SET extension_directory='"+extensionPath+"';")
-				secretsPath := filepath.Join(homePath, ".duckdb/stored_secrets")
-				bootQueries = append(bootQueries, "SET secret_directory='"+secretsPath+"';")
-			}
 
-			// Handle MotherDuck setup and ATTACH
-			if strings.HasPrefix(cleanPath, "md:") {
-				// MotherDuck: install extension, set token, and ATTACH
-				bootQueries = append(bootQueries, "INSTALL 'motherduck';", "LOAD 'motherduck';")
-				bootQueries = append(bootQueries, "SET motherduck_token='"+config.Secrets.MotherDuckToken+"';")
-
-				// Quote the MotherDuck path for ATTACH
-				quotedDB := "'" + strings.ReplaceAll(cleanPath, "'", "''") + "'"
-				bootQueries = append(bootQueries, "ATTACH IF NOT EXISTS "+quotedDB+" (TYPE motherduck);")
-				backend.Logger.Info("ATTACH IF NOT EXISTS " + quotedDB + " (TYPE motherduck);")
-			} else if config.Secrets.MotherDuckToken != "" {
-				// Token provided but not MotherDuck path: still install extension for potential use
-				bootQueries = append(bootQueries, "INSTALL 'motherduck';", "LOAD 'motherduck';")
-				bootQueries = append(bootQueries, "SET motherduck_token='"+config.Secrets.MotherDuckToken+"';")
+	d.mu.Lock()
+	d.converterOptions = converterOptionsFromSettings(config)
+	d.mu.Unlock()
+
+	// connect with the path before any other queries are run. Each connector
+	// keeps its own boot state, so the one-time setup runs again for every
+	// connector opened, like after a reconnect.
+	open := func() (*duckdb.Connector, error) {
+		booted := false
+		initConn := func(execer driver.ExecerContext) error {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			// database/sql opens connections lazily, so this usually runs for
+			// the first query, after ctx (the context of the request that
+			// created the datasource) is done. Using ctx here cancelled the boot
+			// queries, the callback gets no context of its own, so bound them by
+			// the query timeout instead.
+			bootCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if booted {
+				// Later connections of the pool share the extensions, secrets
+				// and attachments but not the session state.
+				return runConnectionQueries(bootCtx, execer, settingQueries, config)
 			}
-			// Run other user defined init queries.
-			if strings.TrimSpace(config.InitSql) != "" {
-				bootQueries = append(bootQueries, config.InitSql)
-			}
-			for _, query := range bootQueries {
-				// TODO: Fix context cancellation happening somewhere in the plugin.
-				_, err = execer.ExecContext(context.Background(), query, nil)
-				if err != nil {
-					return err
-				}
+			if err := runBootQueries(bootCtx, execer, queries, config); err != nil {
+				return err
 			}
 
+			booted = true
 			d.Initialized = true
+			return nil
 		}
+		return duckdb.NewConnector(connectorDSN(path, config), initConn)
+	}
 
-		return nil
+	var (
+		connector *duckdb.Connector
+		release   func() error
+	)
+	if path != "" {
+		// Instances of the same file share its connector, which was booted
+		// by the first of them.
+		fingerprint := bootFingerprint(queries, settingQueries, []string{config.InitSql, searchPath})
+		connector, release, err = sharedConnectors.acquire(ctx, settings.UID, path, config.ReadOnly, fingerprint, open)
+	} else {
+		connector, err = open()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db := sql.OpenDB(&resultConnector{
+		Connector:      connector,
+		flattenStructs: config.FlattenStructs,
+		expandArrays:   config.ExpandArrays,
+		remoteRetry:    remoteRetry,
+		release:        release,
 	})
+	applyPoolSettings(db, config)
+
+	return db, nil
+}
+
+// runBootQueries runs the boot queries followed by the user defined InitSql,
+// stopping as soon as ctx is done.
+func runBootQueries(ctx context.Context, execer driver.ExecerContext, queries []string, config *models.PluginSettings) error {
+	for _, query := range queries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		exec := func() error {
+			_, err := execer.ExecContext(ctx, query, nil)
+			return err
+		}
+		var err error
+		if isMotherDuckBootQuery(query) {
+			err = retryMotherDuck(ctx, exec, config)
+		} else {
+			err = exec()
+		}
+		if err != nil {
+			return bootQueryError(describeStatement(query), err, config)
+		}
+	}
+	// Run other user defined init queries.
+	if err := runInitSql(ctx, execer, config, func(string) bool { return true }); err != nil {
+		return err
+	}
+	return runSearchPath(ctx, execer, config)
+}
+
+// MotherDuck boot statements are retried this many times, waiting
+// motherDuckRetryBackoff before the first retry and twice as long before each
+// following one.
+var (
+	motherDuckRetries      = 3
+	motherDuckRetryBackoff = 500 * time.Millisecond
+)
+
+var (
+	motherDuckBootQueryRegex = regexp.MustCompile(`^((INSTALL|LOAD) 'motherduck'[ ;]|SET motherduck_token=|ATTACH IF NOT EXISTS 'md:)`)
+	// Authentication errors won't go away by retrying.
+	permanentMotherDuckErrorRegex = regexp.MustCompile(`(?i)token|unauthori[sz]ed|unauthenticated|forbidden|permission denied|\b40[13]\b`)
+	transientMotherDuckErrorRegex = regexp.MustCompile(`(?i)timed? ?out|deadline exceeded|connection (refused|reset|closed|failed)|reset by peer|broken pipe|temporar(il)?y|unavailable|could not resolve|no route to host|network|\b50[234]\b|\bEOF\b`)
+)
+
+// isMotherDuckBootQuery reports whether a boot query installs or sets up
+// MotherDuck. These reach out to MotherDuck and may fail while it cold starts.
+func isMotherDuckBootQuery(query string) bool {
+	return motherDuckBootQueryRegex.MatchString(query)
+}
+
+// isTransientMotherDuckError reports whether a failed MotherDuck boot query is
+// worth retrying. Authentication errors, like an invalid token, are not.
+func isTransientMotherDuckError(err error) bool {
+	msg := err.Error()
+	return !permanentMotherDuckErrorRegex.MatchString(msg) && transientMotherDuckErrorRegex.MatchString(msg)
+}
+
+// retryMotherDuck runs exec, retrying transient errors with exponential
+// backoff until motherDuckRetries retries are used up or ctx is done.
+func retryMotherDuck(ctx context.Context, exec func() error, config *models.PluginSettings) error {
+	backoff := motherDuckRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := exec()
+		if err == nil || attempt >= motherDuckRetries || !isTransientMotherDuckError(err) {
+			return err
+		}
+		backend.Logger.Warn("MotherDuck setup failed, retrying", "attempt", attempt+1, "backoff", backoff, "error", redactSecrets(err.Error(), config))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// runConnectionQueries prepares a connection opened after the database was
+// booted. It repeats the settings and the Init SQL statements that only apply
+// to the connection running them, such as temporary views and session SETs.
+func runConnectionQueries(ctx context.Context, execer driver.ExecerContext, queries []string, config *models.PluginSettings) error {
+	for _, query := range queries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := execer.ExecContext(ctx, query, nil); err != nil {
+			return bootQueryError(describeStatement(query), err, config)
+		}
+	}
+	if err := runInitSql(ctx, execer, config, isSessionStatement); err != nil {
+		return err
+	}
+	return runSearchPath(ctx, execer, config)
+}
+
+// runSearchPath sets the search path of a connection. DuckDB checks that the
+// catalogs exist, so it runs after Init SQL, which may attach them.
+func runSearchPath(ctx context.Context, execer driver.ExecerContext, config *models.PluginSettings) error {
+	query, err := searchPathQuery(config)
+	if err != nil || query == "" {
+		return err
+	}
+	if _, err := execer.ExecContext(ctx, query, nil); err != nil {
+		return bootQueryError(describeStatement(query), err, config)
+	}
+	return nil
+}
 
+// runInitSql runs the Init SQL statements accepted by filter.
+func runInitSql(ctx context.Context, execer driver.ExecerContext, config *models.PluginSettings, filter func(string) bool) error {
+	for i, query := range splitStatements(config.InitSql) {
+		if !filter(query) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := execer.ExecContext(ctx, query, nil); err != nil {
+			if !config.InitSqlContinueOnError {
+				return bootQueryError(fmt.Sprintf("InitSql statement %d: %s", i+1, describeStatement(query)), err, config)
+			}
+			// The statement itself is not logged as it may contain credentials.
+			backend.Logger.Warn("Init SQL statement failed, continuing", "statement", i+1, "error", redactSecrets(err.Error(), config))
+		}
+	}
+	return nil
+}
+
+// statementDescriptionLength caps the length of the statements quoted in boot
+// query errors and logs.
+const statementDescriptionLength = 120
+
+var (
+	sensitiveSetRegex    = regexp.MustCompile(`(?i)^(SET\s+(GLOBAL\s+|SESSION\s+)?\w*(token|secret|password|key)\w*)\s*(=|TO\s).*`)
+	secretStatementRegex = regexp.MustCompile(`(?is)^(CREATE\s+(OR\s+REPLACE\s+)?((PERSISTENT|TEMPORARY)\s+)?SECRET\b[^(]*).*`)
+	sensitiveOptionRegex = regexp.MustCompile(`(?i)((token|secret|password)=)[^'&;\s]*`)
+	whitespaceRunRegex   = regexp.MustCompile(`\s+`)
+)
+
+// describeStatement returns a statement as it is shown in errors and logs: on
+// one line, cut short and with credentials like the MotherDuck token or the
+// values of a CREATE SECRET replaced.
+func describeStatement(query string) string {
+	query = strings.TrimSuffix(strings.TrimSpace(leadingCommentsRegex.ReplaceAllString(query, "")), ";")
+	query = whitespaceRunRegex.ReplaceAllString(query, " ")
+	query = sensitiveSetRegex.ReplaceAllString(query, "$1=<redacted>")
+	query = secretStatementRegex.ReplaceAllString(query, "${1}(<redacted>)")
+	query = sensitiveOptionRegex.ReplaceAllString(query, "${1}<redacted>")
+	if len(query) > statementDescriptionLength {
+		query = strings.ToValidUTF8(query[:statementDescriptionLength], "") + "..."
+	}
+	return query
+}
+
+// bootQueryError names the boot query that failed. DuckDB may quote parts of
+// the statement in its errors, so the credentials of the settings are removed
+// from the message as well.
+func bootQueryError(description string, err error, config *models.PluginSettings) error {
+	msg := redactSecrets(err.Error(), config)
+	if msg != err.Error() {
+		return fmt.Errorf("boot query failed [%s]: %s", description, msg)
+	}
+	return fmt.Errorf("boot query failed [%s]: %w", description, err)
+}
+
+var (
+	leadingCommentsRegex  = regexp.MustCompile(`^(\s*(--[^\n]*(\n|$)|/\*(?s:.*?)\*/))*\s*`)
+	sessionStatementRegex = regexp.MustCompile(`(?i)^(SET|RESET|USE|CREATE\s+(OR\s+REPLACE\s+)?TEMP(ORARY)?)\s`)
+	globalStatementRegex  = regexp.MustCompile(`(?i)^(SET|RESET)\s+GLOBAL\s`)
+)
+
+// isSessionStatement reports whether an Init SQL statement only affects the
+// connection running it: temporary objects, USE and non-global SET or RESET.
+func isSessionStatement(query string) bool {
+	query = leadingCommentsRegex.ReplaceAllString(query, "")
+	return sessionStatementRegex.MatchString(query) && !globalStatementRegex.MatchString(query)
+}
+
+// bootQueries returns the statements run on the first connection, before the
+// user defined InitSql.
+func bootQueries(config *models.PluginSettings) ([]string, error) {
+	var err error
+	cleanPath := strings.TrimSpace(config.Path)
+	bootQueries := []string{}
+
+	// read env variable GF_PATHS_DATA and use it as the home directory for extension installation.
+	homePath := os.Getenv("GF_PATHS_DATA")
+
+	if homePath != "" {
+		bootQueries = append(bootQueries, "SET home_directory="+quoteLiteral(homePath)+";")
+		extensionPath := filepath.Join(homePath, ".duckdb/extensions")
+		bootQueries = append(bootQueries, "SET extension_directory="+quoteLiteral(extensionPath)+";")
+		secretsPath := filepath.Join(homePath, ".duckdb/stored_secrets")
+		bootQueries = append(bootQueries, "SET secret_directory="+quoteLiteral(secretsPath)+";")
+	}
+
+	if memoryLimit := strings.TrimSpace(config.MemoryLimit); memoryLimit != "" {
+		if !memoryLimitRegex.MatchString(memoryLimit) {
+			return nil, &ConfigError{"Invalid memory limit: " + memoryLimit + " -> example input: 4GB"}
+		}
+		bootQueries = append(bootQueries, "SET memory_limit="+quoteLiteral(memoryLimit)+";")
+	}
+	if config.Threads < 0 {
+		return nil, &ConfigError{fmt.Sprintf("Invalid number of threads: %d -> must be a positive number", config.Threads)}
+	}
+	if config.Threads > 0 {
+		bootQueries = append(bootQueries, fmt.Sprintf("SET threads=%d;", config.Threads))
+	}
+	if tempDirectory := strings.TrimSpace(config.TempDirectory); tempDirectory != "" {
+		bootQueries = append(bootQueries, "SET temp_directory="+quoteLiteral(tempDirectory)+";")
+	}
+	// The repository has to be set before any extension is installed.
+	if repository := strings.TrimSpace(config.ExtensionRepository); repository != "" {
+		if !validExtensionRepository(repository) {
+			return nil, &ConfigError{"Invalid extension repository: " + repository + " -> example input: https://extensions.example.com or /opt/duckdb/extensions"}
+		}
+		bootQueries = append(bootQueries, "SET custom_extension_repository="+quoteLiteral(repository)+";")
+	}
+	if config.AutoinstallKnownExtensions != nil {
+		bootQueries = append(bootQueries, fmt.Sprintf("SET autoinstall_known_extensions=%t;", *config.AutoinstallKnownExtensions))
+	}
+	if config.AutoloadKnownExtensions != nil {
+		bootQueries = append(bootQueries, fmt.Sprintf("SET autoload_known_extensions=%t;", *config.AutoloadKnownExtensions))
+	}
+	versions := make(map[string]string, len(config.ExtensionVersions))
+	for ext, version := range config.ExtensionVersions {
+		versions[strings.ToLower(strings.TrimSpace(ext))] = strings.TrimSpace(version)
+	}
+	install := func(ext string) []string {
+		quotedExt := quoteLiteral(ext)
+		query := "INSTALL " + quotedExt
+		if version := versions[strings.ToLower(ext)]; version != "" {
+			query += " VERSION " + quoteLiteral(version)
+		}
+		return []string{query + ";", "LOAD " + quotedExt + ";"}
+	}
+
+	// Handle MotherDuck setup and ATTACH
+	if strings.HasPrefix(cleanPath, "md:") {
+		if cleanPath, err = normalizeMotherDuckPath(cleanPath); err != nil {
+			return nil, err
+		}
+		// MotherDuck: install extension, set token, and ATTACH
+		bootQueries = append(bootQueries, install("motherduck")...)
+		bootQueries = append(bootQueries, "SET motherduck_token="+quoteLiteral(config.Secrets.MotherDuckToken)+";")
+
+		// Quote the MotherDuck path for ATTACH. Without an alias DuckDB names
+		// the database after the path.
+		attach := "ATTACH IF NOT EXISTS " + quoteLiteral(cleanPath)
+		if alias := strings.TrimSpace(config.MotherDuckAlias); alias != "" {
+			attach += " AS " + quoteIdentifier(alias)
+		}
+		attach += " (TYPE motherduck);"
+		bootQueries = append(bootQueries, attach)
+		backend.Logger.Info("Attaching MotherDuck database", "path", cleanPath, "alias", config.MotherDuckAlias)
+	} else if config.Secrets.MotherDuckToken != "" {
+		// Token provided but not MotherDuck path: still install extension for potential use
+		bootQueries = append(bootQueries, install("motherduck")...)
+		bootQueries = append(bootQueries, "SET motherduck_token="+quoteLiteral(config.Secrets.MotherDuckToken)+";")
+	}
+
+	// Install and load additional extensions, skipping motherduck when it is
+	// already installed above. MotherDuck attachments need the extension too.
+	installed := map[string]bool{}
+	if strings.HasPrefix(cleanPath, "md:") || config.Secrets.MotherDuckToken != "" {
+		installed["motherduck"] = true
+	}
+	extensions := config.Extensions
+	for _, attachment := range config.Attachments {
+		if isMotherDuckAttachment(attachment) {
+			extensions = append(extensions, "motherduck")
+		}
+	}
+	cloudSecret, cloudExtension, err := cloudSecretQuery(config.Secrets)
 	if err != nil {
 		return nil, err
 	}
+	if cloudExtension != "" {
+		extensions = append(extensions, cloudExtension)
+	}
+	collation, needsICU, err := collationQuery(config)
+	if err != nil {
+		return nil, err
+	}
+	if needsICU {
+		extensions = append(extensions, "icu")
+	}
+	for _, ext := range extensions {
+		ext = strings.TrimSpace(ext)
+		if ext == "" || installed[strings.ToLower(ext)] {
+			continue
+		}
+		installed[strings.ToLower(ext)] = true
+		bootQueries = append(bootQueries, install(ext)...)
+	}
+	// The secret is created before the attachments, which may live in the cloud.
+	if cloudSecret != "" {
+		bootQueries = append(bootQueries, cloudSecret)
+	}
+
+	for _, attachment := range config.Attachments {
+		query, err := attachQuery(attachment)
+		if err != nil {
+			return nil, err
+		}
+		bootQueries = append(bootQueries, query)
+	}
 
-	db := sql.OpenDB(connector)
+	if collation != "" {
+		bootQueries = append(bootQueries, collation)
+	}
 
-	return db, nil
+	// Generic settings go last so they can refer to settings of the extensions
+	// loaded above.
+	settings, err := settingQueries(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(bootQueries, settings...), nil
 }
 
-func (d *DuckDBDriver) Settings(ctx context.Context, settings backend.DataSourceInstanceSettings) sqlds.DriverSettings {
-	return sqlds.DriverSettings{
-		Timeout:        30 * time.Second,
+// settingQueries builds the SET statements for duckdbSettings. Some settings
+// are scoped to the connection, so they are repeated on every new connection.
+// Keys are sorted to keep the order stable.
+func settingQueries(config *models.PluginSettings) ([]string, error) {
+	keys := make([]string, 0, len(config.DuckDBSettings))
+	for key := range config.DuckDBSettings {
+		if !identifierRegex.MatchString(key) {
+			return nil, &ConfigError{"Invalid DuckDB setting name: " + key + " -> example input: s3_region"}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	queries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		queries = append(queries, "SET "+key+"="+quoteLiteral(config.DuckDBSettings[key])+";")
+	}
+	return queries, nil
+}
+
+// builtinCollations are the collations DuckDB has without the icu extension.
+var builtinCollations = map[string]bool{"binary": true, "nocase": true, "noaccent": true, "nfc": true}
+
+// collationRegex matches collation names, which may combine collations with
+// dots, e.g. de.noaccent.
+var collationRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)*$`)
+
+// collationQuery builds the SET default_collation statement for the collation
+// setting and reports whether it uses an ICU collation, which needs the icu
+// extension. DuckDB checks that the collation exists when it is set.
+func collationQuery(config *models.PluginSettings) (string, bool, error) {
+	collation := strings.TrimSpace(config.Collation)
+	if collation == "" {
+		return "", false, nil
+	}
+	if !collationRegex.MatchString(collation) {
+		hint := "example input: de or nocase.noaccent"
+		if strings.Contains(collation, "-") {
+			hint = "ICU collations use underscores, e.g. " + strings.ReplaceAll(collation, "-", "_")
+		}
+		return "", false, &ConfigError{"Invalid collation: " + collation + " -> " + hint}
+	}
+	needsICU := false
+	for _, part := range strings.Split(collation, ".") {
+		needsICU = needsICU || !builtinCollations[strings.ToLower(part)]
+	}
+	return "SET default_collation=" + quoteLiteral(collation) + ";", needsICU, nil
+}
+
+// searchPathQuery builds the SET search_path statement for the searchPath
+// setting, a comma separated list of catalog or catalog.schema names. It is
+// empty when the setting is not set.
+func searchPathQuery(config *models.PluginSettings) (string, error) {
+	value := strings.TrimSpace(config.SearchPath)
+	if value == "" {
+		return "", nil
+	}
+	entries := splitUnquoted(value, ',')
+	for i, entry := range entries {
+		parts := splitUnquoted(entry, '.')
+		if len(parts) > 2 {
+			return "", &ConfigError{"Invalid search path entry: " + strings.TrimSpace(entry) + " -> expected a catalog or catalog.schema name"}
+		}
+		for j, part := range parts {
+			part = strings.TrimSpace(part)
+			if !identifierRegex.MatchString(part) && (len(part) < 3 || quoteIdent(part) != part) {
+				return "", &ConfigError{"Invalid search path entry: " + strings.TrimSpace(entry) + " -> example input: my_db,my_db.reports or \"my db\""}
+			}
+			parts[j] = part
+		}
+		entries[i] = strings.Join(parts, ".")
+	}
+	return "SET search_path=" + quoteLiteral(strings.Join(entries, ",")) + ";", nil
+}
+
+// splitUnquoted splits s on sep outside of double quoted identifiers.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			i = skipQuoted(s, i, '"') - 1
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// quoteLiteral quotes s as a SQL string literal, escaping single quotes.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteIdentifier quotes s as a SQL identifier, escaping double quotes.
+func quoteIdentifier(s string) string {
+	return "\"" + strings.ReplaceAll(s, "\"", "\"\"") + "\""
+}
+
+// quoteIdent quotes a column or table name given to a macro. Surrounding
+// spaces are dropped and a name that is already quoted is kept as it is, so
+// both ts and "ts" refer to the same column. The whole name is one
+// identifier, dots included.
+func quoteIdent(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' &&
+		!strings.Contains(strings.ReplaceAll(name[1:len(name)-1], `""`, ""), `"`) {
+		return name
+	}
+	return quoteIdentifier(name)
+}
+
+// quoteColumn quotes the column argument of a macro where it needs quoting.
+// A name that is not a plain identifier, like order date, or that is a
+// reserved word, like from, is quoted. Qualified names like t.ts are quoted
+// part by part. Quoted parts and expressions like to_timestamp(ts) are kept
+// as they are.
+func quoteColumn(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if !columnNameRegex.MatchString(arg) {
+		return arg
+	}
+	parts := splitUnquoted(arg, '.')
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "" || numberRegex.MatchString(part):
+			// Not a name, e.g. the number 1.5.
+			return arg
+		case part[0] == '"' && quoteIdent(part) == part:
+		case identifierRegex.MatchString(part) && !reservedKeywords[strings.ToLower(part)]:
+		default:
+			part = quoteIdentifier(part)
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, ".")
+}
+
+// columnNameRegex matches the arguments quoteColumn treats as names: letters,
+// digits, underscores, spaces, dots and double quotes only.
+var columnNameRegex = regexp.MustCompile(`^[\p{L}\p{N}_ ."]+$`)
+
+var numberRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// reservedKeywords are the keywords DuckDB does not accept as column names
+// unless they are quoted, as listed by duckdb_keywords().
+var reservedKeywords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true,
+	"array": true, "as": true, "asc": true, "asymmetric": true, "both": true,
+	"case": true, "cast": true, "check": true, "collate": true, "column": true,
+	"constraint": true, "create": true, "default": true, "deferrable": true,
+	"desc": true, "describe": true, "distinct": true, "do": true, "else": true,
+	"end": true, "except": true, "false": true, "fetch": true, "for": true,
+	"foreign": true, "from": true, "group": true, "having": true, "in": true,
+	"initially": true, "intersect": true, "into": true, "lambda": true,
+	"lateral": true, "leading": true, "limit": true, "not": true, "null": true,
+	"offset": true, "on": true, "only": true, "or": true, "order": true,
+	"pivot": true, "pivot_longer": true, "pivot_wider": true, "placing": true,
+	"primary": true, "qualify": true, "references": true, "returning": true,
+	"select": true, "show": true, "some": true, "summarize": true,
+	"symmetric": true, "table": true, "then": true, "to": true, "trailing": true,
+	"true": true, "union": true, "unique": true, "unpivot": true, "using": true,
+	"variadic": true, "when": true, "where": true, "window": true, "with": true,
+}
+
+var memoryLimitRegex = regexp.MustCompile(`(?i)^\d+(\.\d+)?\s*(B|KB|MB|GB|TB|KiB|MiB|GiB|TiB)$`)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validExtensionRepository accepts http(s) and s3 URLs with a host, and
+// absolute paths to a local directory.
+func validExtensionRepository(repository string) bool {
+	if filepath.IsAbs(repository) {
+		return true
+	}
+	u, err := url.Parse(repository)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "s3":
+		return true
+	}
+	return false
+}
+
+// motherDuckParamRegex matches the options of a MotherDuck path, e.g.
+// attach_mode=single.
+var motherDuckParamRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=[A-Za-z0-9_.\-]*$`)
+
+// normalizeMotherDuckPath checks a md: path and removes the whitespace around
+// the database name, e.g. md: my_db becomes md:my_db. The name must not be
+// empty and must not contain whitespace, double quotes, semicolons or
+// backslashes, which are typos rather than part of a database name. Single
+// quotes are escaped when the path is attached.
+func normalizeMotherDuckPath(path string) (string, error) {
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(path), "md:"))
+	name, params, hasParams := strings.Cut(rest, "?")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", &ConfigError{"MotherDuck path " + path + " is missing the database name -> example input: md:my_db"}
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune("\";\\", r) {
+			return "", &ConfigError{fmt.Sprintf("Invalid MotherDuck path: %s -> the database name must not contain %q, example input: md:my_db", path, r)}
+		}
+	}
+	if !hasParams {
+		return "md:" + name, nil
+	}
+	for _, param := range strings.Split(strings.TrimSpace(params), "&") {
+		if !motherDuckParamRegex.MatchString(param) {
+			return "", &ConfigError{"Invalid MotherDuck path option: " + param + " -> example input: md:my_db?attach_mode=single"}
+		}
+	}
+	return "md:" + name + "?" + strings.TrimSpace(params), nil
+}
+
+func isMotherDuckAttachment(attachment models.Attachment) bool {
+	return strings.EqualFold(strings.TrimSpace(attachment.Type), "motherduck") ||
+		strings.HasPrefix(strings.TrimSpace(attachment.Path), "md:")
+}
+
+// cloudSecretQuery builds the CREATE SECRET statement for the cloud storage
+// credentials together with the extension it needs. It returns an empty query
+// when no credentials are configured.
+func cloudSecretQuery(secrets *models.SecretPluginSettings) (string, string, error) {
+	if secrets == nil || (secrets.CloudKeyID == "" && secrets.CloudSecret == "") {
+		return "", "", nil
+	}
+
+	var options []string
+	option := func(name, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			options = append(options, name+" "+quoteLiteral(value))
+		}
+	}
+	extension := "httpfs"
+	switch provider := strings.ToLower(strings.TrimSpace(secrets.CloudProvider)); provider {
+	case "", "s3":
+		options = append(options, "TYPE S3")
+		option("KEY_ID", secrets.CloudKeyID)
+		option("SECRET", secrets.CloudSecret)
+		option("REGION", secrets.CloudRegion)
+		option("ENDPOINT", secrets.CloudEndpoint)
+	case "gcs":
+		options = append(options, "TYPE GCS")
+		option("KEY_ID", secrets.CloudKeyID)
+		option("SECRET", secrets.CloudSecret)
+		option("ENDPOINT", secrets.CloudEndpoint)
+	case "azure":
+		// Azure takes a connection string, or just the account name when the
+		// credentials come from the environment.
+		extension = "azure"
+		options = append(options, "TYPE AZURE")
+		if secrets.CloudSecret != "" {
+			option("CONNECTION_STRING", secrets.CloudSecret)
+		} else {
+			option("ACCOUNT_NAME", secrets.CloudKeyID)
+		}
+	default:
+		return "", "", &ConfigError{"Invalid cloud provider: " + provider + " -> example input: s3, gcs or azure"}
+	}
+
+	return "CREATE OR REPLACE SECRET grafana_cloud (" + strings.Join(options, ", ") + ");", extension, nil
+}
+
+// attachQuery builds the ATTACH statement for an additional database.
+func attachQuery(attachment models.Attachment) (string, error) {
+	path := strings.TrimSpace(attachment.Path)
+	if path == "" {
+		return "", &ConfigError{"Attachment path is missing -> example input: /path/to/database.duckdb"}
+	}
+	if strings.HasPrefix(path, "md:") {
+		var err error
+		if path, err = normalizeMotherDuckPath(path); err != nil {
+			return "", err
+		}
+	}
+	query := "ATTACH IF NOT EXISTS " + quoteLiteral(path)
+
+	if alias := strings.TrimSpace(attachment.Alias); alias != "" {
+		query += " AS " + quoteIdentifier(alias)
+	}
+
+	options := []string{}
+	if attachType := strings.TrimSpace(attachment.Type); attachType != "" {
+		if !identifierRegex.MatchString(attachType) {
+			return "", &ConfigError{"Invalid attachment type: " + attachType + " -> example input: sqlite"}
+		}
+		options = append(options, "TYPE "+attachType)
+	}
+	if attachment.ReadOnly {
+		options = append(options, "READ_ONLY")
+	}
+	if len(options) > 0 {
+		query += " (" + strings.Join(options, ", ") + ")"
+	}
+	return query + ";", nil
+}
+
+// connectorDSN appends the DuckDB options that must be set when the database is
+// opened to the connector path.
+//
+// readOnly only applies to local database files: DuckDB opens the file with
+// access_mode=READ_ONLY, which takes precedence over InitSql. InitSql statements
+// that write to the file (CREATE TABLE, INSERT, ...) fail, while temporary
+// objects and ATTACH keep working.
+func connectorDSN(path string, config *models.PluginSettings) string {
+	params := url.Values{}
+	if config.ReadOnly && path != "" {
+		params.Set("access_mode", "READ_ONLY")
+	}
+	if len(params) == 0 {
+		return path
+	}
+	return path + "?" + params.Encode()
+}
+
+// applyPoolSettings configures the connection pool from the datasource settings.
+// Unset values keep the database/sql defaults.
+func applyPoolSettings(db *sql.DB, config *models.PluginSettings) {
+	if config.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetimeSeconds > 0 {
+		db.SetConnMaxLifetime(time.Duration(config.ConnMaxLifetimeSeconds) * time.Second)
+	}
+}
+
+const defaultQueryTimeout = 30 * time.Second
+
+// queryTimeout parses the queryTimeout setting, falling back to the default
+// when it is not set.
+func queryTimeout(config *models.PluginSettings) (time.Duration, error) {
+	value := strings.TrimSpace(config.QueryTimeout)
+	if value == "" {
+		return defaultQueryTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, &ConfigError{"Invalid query timeout: " + value + " -> example input: 5m"}
+	}
+	return timeout, nil
+}
+
+// maxQueryTimeout parses the maxQueryTimeout setting, the longest timeout a
+// single query may ask for. It is zero when not set.
+func maxQueryTimeout(config *models.PluginSettings) (time.Duration, error) {
+	value := strings.TrimSpace(config.MaxQueryTimeout)
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, &ConfigError{"Invalid max query timeout: " + value + " -> example input: 10m"}
+	}
+	return timeout, nil
+}
+
+const (
+	defaultRetries = 3
+	defaultPause   = 100
+)
+
+// driverSettings builds the sqlds settings from the plugin settings.
+func driverSettings(config *models.PluginSettings) (sqlds.DriverSettings, error) {
+	settings := sqlds.DriverSettings{
+		Timeout:        defaultQueryTimeout,
 		FillMode:       &data.FillMissing{Mode: data.FillModeNull},
-		Retries:        3,
-		Pause:          100,
+		Retries:        defaultRetries,
+		Pause:          defaultPause,
 		RetryOn:        []string{},
 		ForwardHeaders: false,
 		Errors:         false,
 	}
+	if config == nil {
+		return settings, nil
+	}
+
+	timeout, err := queryTimeout(config)
+	if err != nil {
+		return settings, err
+	}
+	maxTimeout, err := maxQueryTimeout(config)
+	if err != nil {
+		return settings, err
+	}
+	// sqlds applies this timeout to every query. Queries may ask for up to
+	// maxQueryTimeout, the wrapper enforces the timeout of each query.
+	settings.Timeout = max(timeout, maxTimeout)
+
+	if config.Retries != nil {
+		if *config.Retries < 0 {
+			return settings, &ConfigError{fmt.Sprintf("Invalid number of retries: %d -> must not be negative", *config.Retries)}
+		}
+		settings.Retries = *config.Retries
+	}
+	if config.Pause != nil {
+		if *config.Pause < 0 {
+			return settings, &ConfigError{fmt.Sprintf("Invalid retry pause: %d -> must not be negative", *config.Pause)}
+		}
+		settings.Pause = *config.Pause
+	}
+	settings.ForwardHeaders = config.ForwardHeaders
+	for _, pattern := range config.RetryOn {
+		// An empty pattern would match every error.
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			settings.RetryOn = append(settings.RetryOn, pattern)
+		}
+	}
+	return settings, nil
+}
 
+// Settings can't return an error, invalid settings fall back to the defaults
+// here and are reported by Connect instead.
+func (d *DuckDBDriver) Settings(ctx context.Context, settings backend.DataSourceInstanceSettings) sqlds.DriverSettings {
+	config, err := models.LoadPluginSettings(settings)
+	if err != nil {
+		config = nil
+	}
+	ds, err := driverSettings(config)
+	if err != nil {
+		ds, _ = driverSettings(nil)
+	}
+	return ds
 }
 
 func (d *DuckDBDriver) FillMode() *data.FillMissing {
 	return &data.FillMissing{Mode: data.FillModeNull}
 }
 
-func (d *DuckDBDriver) Macros() sqlds.Macros {
-	return sqlutil.Macros{
-		"timeFrom": macroTimeFrom,
-		"timeTo":   macroTimeTo,
+func (d *DuckDBDriver) Macros() sqlds.Macros {
+	return sqlutil.Macros{
+		"timeFrom":        macroTimeFrom,
+		"timeTo":          macroTimeTo,
+		"timeFromRounded": macroTimeFromRounded,
+		"timeToRounded":   macroTimeToRounded,
+		"timeFromEpoch":   macroTimeFromEpoch,
+		"timeToEpoch":     macroTimeToEpoch,
+		"timeFilter":      macroTimeFilter,
+		"timeGroup":       macroTimeGroup,
+		"interval":        macroInterval,
+		"unixEpochFilter": macroUnixEpochFilter,
+		"unixEpochGroup":  macroUnixEpochGroup,
+		"readFiles":       macroReadFiles,
+		"inClause":        macroInClause,
+		"unnest":          macroUnnest,
+		"quoteIdent":      macroQuoteIdent,
+	}
+}
+
+// macroTime returns a time of the query range in the timezone it is emitted in.
+// The SDK decodes the range in the local timezone of the process, which is
+// replaced by UTC. withQueryTimezone moves the range to the dashboard timezone.
+func macroTime(t time.Time) time.Time {
+	if t.Location() == time.Local {
+		return t.UTC()
+	}
+	return t
+}
+
+func macroTimeFrom(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) == 0 || (len(args) == 1 && strings.TrimSpace(args[0]) == "") {
+		return "'" + macroTime(query.TimeRange.From).Format(time.RFC3339) + "'", nil
+	}
+	return "", fmt.Errorf("%w: expected 0 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+}
+
+func macroTimeTo(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) == 0 || (len(args) == 1 && strings.TrimSpace(args[0]) == "") {
+		return "'" + macroTime(query.TimeRange.To).Format(time.RFC3339) + "'", nil
+	}
+	return "", fmt.Errorf("%w: expected 0 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+}
+
+// macroTimeFromEpoch expands to the start of the time range as a unix epoch,
+// in seconds by default or in milliseconds with $__timeFromEpoch(ms).
+func macroTimeFromEpoch(query *sqlutil.Query, args []string) (string, error) {
+	return formatEpoch(query.TimeRange.From, args)
+}
+
+// macroTimeToEpoch expands to the end of the time range as a unix epoch, see
+// macroTimeFromEpoch.
+func macroTimeToEpoch(query *sqlutil.Query, args []string) (string, error) {
+	return formatEpoch(query.TimeRange.To, args)
+}
+
+func formatEpoch(t time.Time, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", fmt.Errorf("%w: expected 0 or 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	unit := ""
+	if len(args) == 1 {
+		unit = strings.TrimSpace(args[0])
+	}
+	switch unit {
+	case "", "s":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "ms":
+		return strconv.FormatInt(t.UnixMilli(), 10), nil
+	default:
+		return "", fmt.Errorf("invalid epoch unit %q: expected s or ms", unit)
+	}
+}
+
+func macroTimeFilter(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	column := quoteColumn(args[0])
+	from := macroTime(query.TimeRange.From).Format(time.RFC3339)
+	to := macroTime(query.TimeRange.To).Format(time.RFC3339)
+	return fmt.Sprintf("%s >= '%s' AND %s <= '%s'", column, from, column, to), nil
+}
+
+var macroIntervalRegex = regexp.MustCompile(`^(\d+)(ms|s|m|h|d|w)$`)
+
+var macroIntervalUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// parseMacroInterval parses a Grafana style interval such as 30s, 5m or 1d.
+func parseMacroInterval(arg string) (time.Duration, error) {
+	match := macroIntervalRegex.FindStringSubmatch(strings.TrimSpace(arg))
+	if match == nil {
+		return 0, fmt.Errorf("invalid interval %q: expected a number followed by one of ms, s, m, h, d, w (e.g. 5m)", arg)
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q: %w", arg, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("invalid interval %q: must be greater than zero", arg)
+	}
+	return time.Duration(n) * macroIntervalUnits[match[2]], nil
+}
+
+var duckdbIntervalUnits = []struct {
+	unit     time.Duration
+	duckName string
+}{
+	{24 * time.Hour, "days"},
+	{time.Hour, "hours"},
+	{time.Minute, "minutes"},
+	{time.Second, "seconds"},
+	{time.Millisecond, "milliseconds"},
+	{time.Microsecond, "microseconds"},
+}
+
+// formatDuckDBInterval formats d as a DuckDB INTERVAL literal using the largest
+// unit that represents it exactly, e.g. INTERVAL '5 minutes'.
+func formatDuckDBInterval(d time.Duration) string {
+	for _, u := range duckdbIntervalUnits {
+		if d%u.unit == 0 {
+			return fmt.Sprintf("INTERVAL '%d %s'", d/u.unit, u.duckName)
+		}
+	}
+	return fmt.Sprintf("INTERVAL '%d microseconds'", d/time.Microsecond)
+}
+
+// macroTimeGroup buckets a timestamp column into fixed intervals, e.g.
+// $__timeGroup(ts, 5m) becomes time_bucket(INTERVAL '5 minutes', "ts"). The
+// interval is widened when the time range would have more buckets than the
+// panel's maxDataPoints.
+func macroTimeGroup(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 2 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	interval, err := parseMacroInterval(args[1])
+	if err != nil {
+		return "", err
+	}
+	interval = clampInterval(query, interval)
+	return fmt.Sprintf("time_bucket(%s, %s)", formatDuckDBInterval(interval), quoteColumn(args[0])), nil
+}
+
+// macroInterval expands to Grafana's computed interval for the panel as a
+// DuckDB INTERVAL literal, e.g. INTERVAL '15 seconds', widened like the
+// interval of $__timeGroup to respect maxDataPoints.
+func macroInterval(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) > 1 || (len(args) == 1 && strings.TrimSpace(args[0]) != "") {
+		return "", fmt.Errorf("%w: expected 0 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	if query.Interval <= 0 {
+		return "", errors.New("$__interval is not available: the query has no interval")
+	}
+	return formatDuckDBInterval(clampInterval(query, query.Interval)), nil
+}
+
+// clampInterval returns the interval to bucket the time range by so that it
+// has at most query.MaxDataPoints buckets: max(interval, range/maxDataPoints).
+// The minimum is rounded up to whole seconds, or milliseconds below a second,
+// to keep the INTERVAL literals readable.
+func clampInterval(query *sqlutil.Query, interval time.Duration) time.Duration {
+	span := query.TimeRange.To.Sub(query.TimeRange.From)
+	if query.MaxDataPoints <= 0 || span <= 0 {
+		return interval
+	}
+	points := time.Duration(query.MaxDataPoints)
+	minInterval := (span + points - 1) / points
+	unit := time.Millisecond
+	if minInterval > time.Second {
+		unit = time.Second
+	}
+	minInterval = (minInterval + unit - 1) / unit * unit
+	return max(interval, minInterval)
+}
+
+// macroUnixEpochFilter filters a column holding unix epoch seconds on the time range.
+func macroUnixEpochFilter(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	column := quoteColumn(args[0])
+	return fmt.Sprintf("%s >= %d AND %s <= %d", column, query.TimeRange.From.Unix(), column, query.TimeRange.To.Unix()), nil
+}
+
+// macroUnixEpochGroup buckets a column holding unix epoch seconds into windows
+// of the given interval, e.g. $__unixEpochGroup(ts, 5m) becomes ("ts" // 300) * 300.
+func macroUnixEpochGroup(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 2 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	interval, err := parseMacroInterval(args[1])
+	if err != nil {
+		return "", err
+	}
+	if interval%time.Second != 0 {
+		return "", fmt.Errorf("invalid interval %q: unix epoch buckets must be whole seconds", strings.TrimSpace(args[1]))
+	}
+	seconds := int64(interval / time.Second)
+	return fmt.Sprintf("(%s // %d) * %d", quoteColumn(args[0]), seconds, seconds), nil
+}
+
+func roundedIntervalArg(args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return parseMacroInterval(args[0])
+}
+
+// macroTimeFromRounded rounds the start of the time range down to the interval
+// boundary so the query text stays stable across small time shifts.
+func macroTimeFromRounded(query *sqlutil.Query, args []string) (string, error) {
+	interval, err := roundedIntervalArg(args)
+	if err != nil {
+		return "", err
+	}
+	rounded := *query
+	rounded.TimeRange.From = floorTime(macroTime(query.TimeRange.From), interval)
+	return macroTimeFrom(&rounded, nil)
+}
+
+// macroTimeToRounded rounds the end of the time range up to the interval boundary.
+func macroTimeToRounded(query *sqlutil.Query, args []string) (string, error) {
+	interval, err := roundedIntervalArg(args)
+	if err != nil {
+		return "", err
 	}
+	rounded := *query
+	rounded.TimeRange.To = ceilTime(macroTime(query.TimeRange.To), interval)
+	return macroTimeTo(&rounded, nil)
 }
 
-func macroTimeFrom(query *sqlutil.Query, args []string) (string, error) {
-	if len(args) == 0 || (len(args) == 1 && strings.TrimSpace(args[0]) == "") {
-		return "'" + query.TimeRange.From.UTC().Format(time.RFC3339) + "'", nil
+const calendarDay = 24 * time.Hour
+
+// floorTime rounds t down to a multiple of interval. time.Time.Truncate counts
+// from the zero time in UTC, so intervals of whole days are counted in the
+// calendar days of the location of t instead, and 1d rounds to midnight in the
+// dashboard timezone, also on days with a daylight saving change.
+func floorTime(t time.Time, interval time.Duration) time.Time {
+	if interval%calendarDay != 0 {
+		return t.Truncate(interval)
 	}
-	return "", fmt.Errorf("%w: expected 0 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	year, month, date := t.Date()
+	days := (time.Date(year, month, date, 0, 0, 0, 0, time.UTC).Unix() - time.Time{}.Unix()) / int64(calendarDay/time.Second)
+	days -= days % int64(interval/calendarDay)
+	return time.Date(1, time.January, 1+int(days), 0, 0, 0, 0, t.Location())
 }
 
-func macroTimeTo(query *sqlutil.Query, args []string) (string, error) {
-	if len(args) == 0 || (len(args) == 1 && strings.TrimSpace(args[0]) == "") {
-		return "'" + query.TimeRange.To.UTC().Format(time.RFC3339) + "'", nil
+// ceilTime rounds t up to a multiple of interval, like floorTime.
+func ceilTime(t time.Time, interval time.Duration) time.Time {
+	floor := floorTime(t, interval)
+	if !floor.Before(t) {
+		return floor
 	}
-	return "", fmt.Errorf("%w: expected 0 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	if interval%calendarDay != 0 {
+		return floor.Add(interval)
+	}
+	year, month, date := floor.Date()
+	return time.Date(year, month, date+int(interval/calendarDay), 0, 0, 0, 0, floor.Location())
 }
 
 func (d *DuckDBDriver) Converters() []sqlutil.Converter {
-	return GetConverterList()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append(converterList(d.converterOptions), d.CustomConverters...)
 }
 
 // From https://github.com/snakedotdev/grafana-duckdb-datasource
@@ -229,8 +1280,8 @@ func (n *NullBigInt) Scan(value any) error {
 	}
 	n.Valid = true
 	bi, ok := value.(*big.Int)
-	if !ok {
-		backend.Logger.Info("got unexpected value not of big.Int type: %+v", value)
+	if !ok || bi == nil {
+		backend.Logger.Warn("Unexpected value for a big integer column", "type", fmt.Sprintf("%T", value), "value", value)
 		n.BigInt = nil
 		n.Valid = false
 		return errors.New("expected value to be big.Int")
@@ -246,33 +1297,109 @@ func (n *NullBigInt) Value() (driver.Value, error) {
 	return n.BigInt, nil
 }
 
-func GetConverterList() []sqlutil.Converter {
+type unsigned interface {
+	uint8 | uint16 | uint32 | uint64
+}
+
+// NullUnsigned is a wrapper for unsigned integers that implements sql.Scanner
+type NullUnsigned[T unsigned] struct {
+	Uint  T
+	Valid bool
+}
+
+func (n *NullUnsigned[T]) Scan(value any) error {
+	if value == nil {
+		n.Uint = 0
+		n.Valid = false
+		return nil
+	}
+	v, ok := value.(T)
+	if !ok {
+		n.Uint = 0
+		n.Valid = false
+		return fmt.Errorf("expected value to be %T, got %T", n.Uint, value)
+	}
+	n.Uint = v
+	n.Valid = true
+	return nil
+}
+
+func (n *NullUnsigned[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Uint, nil
+}
+
+// GetConverterList returns the converters with the default options, followed
+// by any extra converters.
+func GetConverterList(extra ...sqlutil.Converter) []sqlutil.Converter {
+	return append(converterList(converterOptions{}), extra...)
+}
+
+func converterList(opts converterOptions) []sqlutil.Converter {
 	// NEED:
-	// NULL to uint64, uint32, uint16, uint8,  not supported
-	// Names: BIT, UBIGINT, UHUGEINT, UINTEGER, USMALLINT, UTINYINT
+	// BIT columns are not scanned by duckdb-go yet, the query fails with
+	// "unsupported data type: BIT" before any converter runs. Casting to VARCHAR
+	// (col::VARCHAR) returns the bitstring as text.
+	// The same goes for VARINT, which DuckDB reports as BIGNUM since 1.4 and
+	// the driver fails on with "unsupported data type: BIGNUM".
+
+	// There's no numerical FieldType that's big enough for HUGEINTs, so
+	// output as string.
+	bigIntToString := func(in interface{}) (interface{}, error) {
+		v := in.(*NullBigInt)
+		if !v.Valid || v.BigInt == nil {
+			return (*string)(nil), nil
+		}
+		str := formatBigInt(v.BigInt)
+		return &str, nil
+	}
+
+	bigIntFrameConverter := sqlutil.FrameConverter{
+		FieldType:     data.FieldTypeNullableString,
+		ConverterFunc: bigIntToString,
+	}
+	if opts.hugeIntAsFloat {
+		// Values beyond 2^53 lose precision.
+		bigIntFrameConverter = sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableFloat64,
+			ConverterFunc: func(in interface{}) (interface{}, error) {
+				v := in.(*NullBigInt)
+				if !v.Valid || v.BigInt == nil {
+					return (*float64)(nil), nil
+				}
+				f, _ := v.BigInt.Float64()
+				return &f, nil
+			},
+		}
+	}
 
-	// Add converter for HUGEINT that returns *big.Int
+	// Add converters for HUGEINT and UHUGEINT that return *big.Int
 	bigIntConverters := []sqlutil.Converter{
 		{
-			Name:          "handle HUGEINT (returns *big.Int)",
-			InputScanType: reflect.TypeOf(NullBigInt{}),
-			InputTypeName: "HUGEINT",
-			FrameConverter: sqlutil.FrameConverter{
-				// There's no numerical FieldType that's big enough for HUGEINTs, so
-				// output as string.
-				FieldType: data.FieldTypeNullableString,
-				ConverterFunc: func(in interface{}) (interface{}, error) {
-					v := in.(*NullBigInt)
-					if !v.Valid || v.BigInt == nil {
-						return (*string)(nil), nil
-					}
-					str := v.BigInt.String()
-					return &str, nil
-				},
-			},
+			Name:           "handle HUGEINT (returns *big.Int)",
+			InputScanType:  reflect.TypeOf(NullBigInt{}),
+			InputTypeName:  "HUGEINT",
+			FrameConverter: bigIntFrameConverter,
+		},
+		{
+			Name:           "handle UHUGEINT (returns *big.Int)",
+			InputScanType:  reflect.TypeOf(NullBigInt{}),
+			InputTypeName:  "UHUGEINT",
+			FrameConverter: bigIntFrameConverter,
 		},
 	}
 
+	// The default converters scan unsigned integers into plain uints, which
+	// fails on NULL.
+	unsignedConverters := []sqlutil.Converter{
+		unsignedConverter[uint8]("UTINYINT"),
+		unsignedConverter[uint16]("USMALLINT"),
+		unsignedConverter[uint32]("UINTEGER"),
+		unsignedConverter[uint64]("UBIGINT"),
+	}
+
 	strConverters := sqlutil.ToConverters([]sqlutil.StringConverter{
 		{
 			Name:           "handle FLOAT8",
@@ -294,39 +1421,23 @@ func GetConverterList() []sqlutil.Converter {
 			},
 		},
 		{
-			Name:           "handle FLOAT32",
-			InputScanKind:  reflect.Interface,
-			InputTypeName:  "FLOAT32",
-			ConversionFunc: func(in *string) (*string, error) { return in, nil },
-			Replacer: &sqlutil.StringFieldReplacer{
-				OutputFieldType: data.FieldTypeNullableFloat64,
-				ReplaceFunc: func(in *string) (any, error) {
-					if in == nil {
-						return nil, nil
-					}
-					v, err := strconv.ParseFloat(*in, 64)
-					if err != nil {
-						return nil, err
-					}
-					return &v, nil
-				},
-			},
-		},
-		{
+			// DuckDB reports single precision columns (FLOAT, REAL, FLOAT4) as
+			// FLOAT, they keep their precision as float32.
 			Name:           "handle FLOAT",
 			InputScanKind:  reflect.Interface,
 			InputTypeName:  "FLOAT",
 			ConversionFunc: func(in *string) (*string, error) { return in, nil },
 			Replacer: &sqlutil.StringFieldReplacer{
-				OutputFieldType: data.FieldTypeNullableFloat64,
+				OutputFieldType: data.FieldTypeNullableFloat32,
 				ReplaceFunc: func(in *string) (any, error) {
 					if in == nil {
 						return nil, nil
 					}
-					v, err := strconv.ParseFloat(*in, 64)
+					f64, err := strconv.ParseFloat(*in, 32)
 					if err != nil {
 						return nil, err
 					}
+					v := float32(f64)
 					return &v, nil
 				},
 			},
@@ -352,21 +1463,21 @@ func GetConverterList() []sqlutil.Converter {
 			},
 		},
 		{
+			// INT8 is DuckDB's alias for the 8-byte BIGINT, not an 8-bit integer.
 			Name:           "handle INT8",
 			InputScanKind:  reflect.Interface,
 			InputTypeName:  "INT8",
 			ConversionFunc: func(in *string) (*string, error) { return in, nil },
 			Replacer: &sqlutil.StringFieldReplacer{
-				OutputFieldType: data.FieldTypeNullableInt16,
+				OutputFieldType: data.FieldTypeNullableInt64,
 				ReplaceFunc: func(in *string) (any, error) {
 					if in == nil {
 						return nil, nil
 					}
-					i64, err := strconv.ParseInt(*in, 10, 16)
+					v, err := strconv.ParseInt(*in, 10, 64)
 					if err != nil {
 						return nil, err
 					}
-					v := int16(i64)
 					return &v, nil
 				},
 			},
@@ -377,16 +1488,16 @@ func GetConverterList() []sqlutil.Converter {
 			InputTypeName:  "TINYINT",
 			ConversionFunc: func(in *string) (*string, error) { return in, nil },
 			Replacer: &sqlutil.StringFieldReplacer{
-				OutputFieldType: data.FieldTypeNullableInt16,
+				OutputFieldType: data.FieldTypeNullableInt8,
 				ReplaceFunc: func(in *string) (any, error) {
 					if in == nil {
 						return nil, nil
 					}
-					i64, err := strconv.ParseInt(*in, 10, 16)
+					i64, err := strconv.ParseInt(*in, 10, 8)
 					if err != nil {
 						return nil, err
 					}
-					v := int16(i64)
+					v := int8(i64)
 					return &v, nil
 				},
 			},
@@ -433,26 +1544,525 @@ func GetConverterList() []sqlutil.Converter {
 		},
 	}...,
 	)
-	converters := []sqlutil.Converter{
+	// DuckDB returns timestamps in UTC already, converting them again makes sure
+	// Grafana never applies a local offset.
+	timeConverters := []sqlutil.Converter{
+		utcTimeConverter("TIMESTAMPTZ"),
+		utcTimeConverter("TIMESTAMP WITH TIME ZONE"),
+		utcTimeConverter("TIMESTAMP"),
+		// duckdb-go scales the other precisions itself, TIMESTAMP_US is reported
+		// as TIMESTAMP.
+		utcTimeConverter("TIMESTAMP_NS"),
+		utcTimeConverter("TIMESTAMP_US"),
+		utcTimeConverter("TIMESTAMP_MS"),
+		utcTimeConverter("TIMESTAMP_S"),
+		utcTimeConverter("DATE"),
 		{
-			Name:           "NULLABLE decimal converter",
-			InputScanType:  reflect.TypeOf(NullDecimal{}),
-			InputTypeRegex: regexp.MustCompile("DECIMAL.*"),
+			// Grafana has no time of day field type.
+			Name:          "handle TIME",
+			InputScanType: reflect.TypeOf(sql.NullTime{}),
+			InputTypeName: "TIME",
 			FrameConverter: sqlutil.FrameConverter{
-				FieldType: data.FieldTypeNullableFloat64,
-				ConverterFunc: func(n interface{}) (interface{}, error) {
-					v := n.(*NullDecimal)
-
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					v := in.(*sql.NullTime)
 					if !v.Valid {
-						return (*float64)(nil), nil
+						return (*string)(nil), nil
 					}
+					str := v.Time.Format("15:04:05.999999")
+					return &str, nil
+				},
+			},
+		},
+	}
+
+	decimalFrameConverter := sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableFloat64,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*NullDecimal)
 
-					f := v.Decimal.Float64()
-					return &f, nil
+			if !v.Valid {
+				return (*float64)(nil), nil
+			}
+
+			f := v.Decimal.Float64()
+			return &f, nil
+		},
+	}
+	if opts.decimalAsString {
+		decimalFrameConverter = sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableString,
+			ConverterFunc: func(n interface{}) (interface{}, error) {
+				v := n.(*NullDecimal)
+
+				if !v.Valid || v.Decimal.Value == nil {
+					return (*string)(nil), nil
+				}
+
+				str := decimalString(v.Decimal)
+				return &str, nil
+			},
+		}
+	}
+
+	converters := []sqlutil.Converter{
+		{
+			Name:          "handle UUID",
+			InputScanType: reflect.TypeOf(sql.Null[duckdb.UUID]{}),
+			InputTypeName: "UUID",
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					v := in.(*sql.Null[duckdb.UUID])
+					if !v.Valid {
+						return (*string)(nil), nil
+					}
+					str := v.V.String()
+					return &str, nil
+				},
+			},
+		},
+		{
+			// Scan into sql.NullBool so that NULL never turns into false.
+			Name:          "handle BOOLEAN",
+			InputScanType: reflect.TypeOf(sql.NullBool{}),
+			InputTypeName: "BOOLEAN",
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableBool,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					v := in.(*sql.NullBool)
+					if !v.Valid {
+						return (*bool)(nil), nil
+					}
+					b := v.Bool
+					return &b, nil
+				},
+			},
+		},
+		{
+			// duckdb-go reports ENUM columns as "ENUM" and scans the label, other
+			// drivers include the labels in the type name.
+			Name:           "handle ENUM",
+			InputScanType:  reflect.TypeOf(sql.NullString{}),
+			InputTypeRegex: regexp.MustCompile(`^ENUM(\(.*\))?$`),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					v := in.(*sql.NullString)
+					if !v.Valid {
+						return (*string)(nil), nil
+					}
+					str := v.String
+					return &str, nil
+				},
+			},
+		},
+		{
+			Name:          "handle INTERVAL",
+			InputScanType: reflect.TypeOf(sql.Null[duckdb.Interval]{}),
+			InputTypeName: "INTERVAL",
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					v := in.(*sql.Null[duckdb.Interval])
+					if !v.Valid {
+						return (*string)(nil), nil
+					}
+					str := intervalString(v.V)
+					return &str, nil
+				},
+			},
+		},
+		{
+			// BLOBs are not necessarily valid UTF-8, so they are base64 encoded.
+			Name:          "handle BLOB",
+			InputScanType: reflect.TypeOf(sql.Null[[]byte]{}),
+			InputTypeName: "BLOB",
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					v := in.(*sql.Null[[]byte])
+					if !v.Valid {
+						return (*string)(nil), nil
+					}
+					str := base64.StdEncoding.EncodeToString(v.V)
+					return &str, nil
 				},
 			},
 		},
+		// duckdb-go decodes the values of JSON columns itself, so a Go string
+		// is a JSON string scalar rather than JSON text. Every value is encoded
+		// again, in compact form with sorted keys and float64 numbers.
+		jsonConverter("handle JSON", regexp.MustCompile(`^JSON$`), toJSONValue),
+		jsonConverter("handle STRUCT", regexp.MustCompile(`^STRUCT\(.*\)$`), toJSONValue),
+		// The JSON shape of a MAP column follows its key type, so empty maps
+		// have the shape of the other values.
+		jsonConverter("handle MAP", regexp.MustCompile(`^MAP\(VARCHAR, .*\)$`), toJSONValue),
+		jsonConverter("handle MAP with other keys", regexp.MustCompile(`^MAP\(.*\)$`), mapEntriesJSONValue),
+		jsonConverter("handle UNION", regexp.MustCompile(`^UNION\(.*\)$`), toJSONValue),
+		// LISTs and ARRAYs are named after their element type, e.g. INTEGER[]
+		// or STRUCT("a" INTEGER)[3].
+		jsonConverter("handle LIST", regexp.MustCompile(`\[\d*\]$`), toJSONValue),
+		{
+			Name:           "NULLABLE decimal converter",
+			InputScanType:  reflect.TypeOf(NullDecimal{}),
+			InputTypeRegex: regexp.MustCompile("DECIMAL.*"),
+			FrameConverter: decimalFrameConverter,
+		},
 	}
-	allConverters := append(bigIntConverters, converters...)
+	allConverters := append(bigIntConverters, unsignedConverters...)
+	allConverters = append(allConverters, timeConverters...)
+	allConverters = append(allConverters, converters...)
 	return append(allConverters, strConverters...)
 }
+
+// unsignedConverter scans columns of the given DuckDB type into NullUnsigned[T]
+// and outputs the matching nullable field type, so NULL values become nil.
+func unsignedConverter[T unsigned](typeName string) sqlutil.Converter {
+	return sqlutil.Converter{
+		Name:          "handle " + typeName,
+		InputScanType: reflect.TypeOf(NullUnsigned[T]{}),
+		InputTypeName: typeName,
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeFor((*T)(nil)),
+			ConverterFunc: func(in interface{}) (interface{}, error) {
+				v := in.(*NullUnsigned[T])
+				if !v.Valid {
+					return (*T)(nil), nil
+				}
+				val := v.Uint
+				return &val, nil
+			},
+		},
+	}
+}
+
+// Grafana encodes times as nanoseconds since the epoch in an int64.
+var (
+	minFrameTime = time.Unix(0, math.MinInt64)
+	maxFrameTime = time.Unix(0, math.MaxInt64)
+)
+
+// utcTimeConverter scans columns of the given DuckDB type into sql.NullTime and
+// outputs nullable times normalized to UTC. Times outside of the range Grafana
+// can encode, like 'infinity' or far-future TIMESTAMP_S values, would overflow
+// and are converted to NULL.
+func utcTimeConverter(typeName string) sqlutil.Converter {
+	return sqlutil.Converter{
+		Name:          "handle " + typeName,
+		InputScanType: reflect.TypeOf(sql.NullTime{}),
+		InputTypeName: typeName,
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableTime,
+			ConverterFunc: func(in interface{}) (interface{}, error) {
+				v := in.(*sql.NullTime)
+				if !v.Valid || v.Time.Before(minFrameTime) || v.Time.After(maxFrameTime) {
+					return (*time.Time)(nil), nil
+				}
+				t := v.Time.UTC()
+				return &t, nil
+			},
+		},
+	}
+}
+
+// jsonConverter outputs the columns matching typeRegex as JSON strings, for
+// nested types Grafana has no field type for. encode converts the scanned
+// values into values encoding/json can marshal.
+func jsonConverter(name string, typeRegex *regexp.Regexp, encode func(any) any) sqlutil.Converter {
+	return sqlutil.Converter{
+		Name:           name,
+		InputScanType:  reflect.TypeOf(sql.Null[any]{}),
+		InputTypeRegex: typeRegex,
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableString,
+			ConverterFunc: func(in interface{}) (interface{}, error) {
+				v := in.(*sql.Null[any])
+				if !v.Valid {
+					return (*string)(nil), nil
+				}
+				b, err := json.Marshal(encode(v.V))
+				if err != nil {
+					return nil, err
+				}
+				str := string(b)
+				return &str, nil
+			},
+		},
+	}
+}
+
+// toJSONValue converts values scanned from nested DuckDB types into values
+// encoding/json can marshal. STRUCTs are scanned as map[string]any and become
+// JSON objects, the keys are sorted by encoding/json.
+//
+// MAPs with VARCHAR keys become JSON objects as well. Stringifying other keys
+// would make e.g. 1 and '1' collide, so those maps become an array of
+// {"key": ..., "value": ...} objects ordered by key instead. Nested maps carry
+// no key type, an empty one becomes an empty object.
+//
+// UNIONs become {"tag": ..., "value": ...} objects holding the active member.
+// The other member types are lost.
+func toJSONValue(v any) any {
+	switch v := v.(type) {
+	case duckdb.Map:
+		if object, ok := stringKeyedMap(v); ok {
+			return object
+		}
+		return mapEntries(v)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			out[key] = toJSONValue(value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = toJSONValue(value)
+		}
+		return out
+	case duckdb.Union:
+		return unionValue{Tag: v.Tag, Value: toJSONValue(v.Value)}
+	default:
+		return v
+	}
+}
+
+// unionValue is the JSON form of a UNION value, holding its active member.
+type unionValue struct {
+	Tag   string `json:"tag"`
+	Value any    `json:"value"`
+}
+
+type mapEntry struct {
+	Key   any `json:"key"`
+	Value any `json:"value"`
+}
+
+// mapEntriesJSONValue is toJSONValue for the values of MAP columns with keys
+// other than VARCHAR, which become arrays of entries even when they are empty.
+func mapEntriesJSONValue(v any) any {
+	if m, ok := v.(duckdb.Map); ok {
+		return mapEntries(m)
+	}
+	return toJSONValue(v)
+}
+
+// mapEntries returns the entries of m ordered by key.
+func mapEntries(m duckdb.Map) []mapEntry {
+	pairs := make([]mapEntry, 0, len(m))
+	for key, value := range m {
+		pairs = append(pairs, mapEntry{Key: toJSONValue(key), Value: toJSONValue(value)})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return lessMapKey(pairs[i].Key, pairs[j].Key) })
+	return pairs
+}
+
+func stringKeyedMap(m duckdb.Map) (map[string]any, bool) {
+	out := make(map[string]any, len(m))
+	for key, value := range m {
+		str, ok := key.(string)
+		if !ok {
+			return nil, false
+		}
+		out[str] = toJSONValue(value)
+	}
+	return out, true
+}
+
+// lessMapKey orders numeric keys by value and any other keys by their string
+// representation.
+func lessMapKey(a, b any) bool {
+	av, aok := toFloat(a)
+	bv, bok := toFloat(b)
+	if aok && bok {
+		return av < bv
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// intervalString formats an interval the way DuckDB casts it to VARCHAR, e.g.
+// "1 year 2 months 3 days 04:05:06.789".
+func intervalString(i duckdb.Interval) string {
+	parts := []string{}
+	unit := func(n int64, name string) {
+		if n == 0 {
+			return
+		}
+		if n != 1 && n != -1 {
+			name += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n, name))
+	}
+	unit(int64(i.Months/12), "year")
+	unit(int64(i.Months%12), "month")
+	unit(int64(i.Days), "day")
+
+	if i.Micros != 0 || len(parts) == 0 {
+		micros := i.Micros
+		sign := ""
+		if micros < 0 {
+			sign = "-"
+			micros = -micros
+		}
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, micros/3_600_000_000, micros/60_000_000%60, micros/1_000_000%60)
+		if fraction := micros % 1_000_000; fraction != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", fraction), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+// decimalString formats a decimal with all digits of its scale, e.g. 1.50 for
+// a DECIMAL(10,2). duckdb.Decimal.String() trims trailing zeros.
+func decimalString(d duckdb.Decimal) string {
+	digits := new(big.Int).Abs(d.Value).String()
+	sign := ""
+	if d.Value.Sign() < 0 {
+		sign = "-"
+	}
+	scale := int(d.Scale)
+	if scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// readFilesFunctions maps the formats accepted by $__readFiles to the DuckDB
+// table function reading them.
+var readFilesFunctions = map[string]string{
+	"parquet": "read_parquet",
+	"csv":     "read_csv_auto",
+	"json":    "read_json_auto",
+}
+
+// macroReadFiles reads files matching a glob with the table function of the
+// format, e.g. $__readFiles('s3://bucket/*.parquet', parquet) becomes
+// read_parquet('s3://bucket/*.parquet'). The glob may be quoted or not.
+func macroReadFiles(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 2 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	glob := strings.TrimSpace(args[0])
+	if len(glob) >= 2 && strings.HasPrefix(glob, "'") && strings.HasSuffix(glob, "'") {
+		glob = strings.ReplaceAll(glob[1:len(glob)-1], "''", "'")
+	}
+	format := strings.ToLower(strings.TrimSpace(args[1]))
+	function, ok := readFilesFunctions[format]
+	if !ok {
+		return "", fmt.Errorf("invalid file format %q: expected one of csv, json, parquet", strings.TrimSpace(args[1]))
+	}
+	return function + "(" + quoteLiteral(glob) + ")", nil
+}
+
+// macroInClause filters a column on the values of a multi-value variable, e.g.
+// $__inClause(host, 'a','b') becomes "host" IN ('a', 'b'). Values may be
+// quoted or not and are always emitted as escaped string literals. Without any
+// value it expands to FALSE, so an empty selection matches no rows.
+func macroInClause(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) == 0 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected at least 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	column := quoteColumn(args[0])
+
+	// sqlutil splits the arguments on every comma, including the ones inside
+	// quoted values, so parse the values again. It also trims the arguments,
+	// which drops spaces next to commas inside quoted values.
+	values, err := parseInClauseValues(strings.Join(args[1:], ","))
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "FALSE", nil
+	}
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = quoteLiteral(value)
+	}
+	return column + " IN (" + strings.Join(literals, ", ") + ")", nil
+}
+
+// macroUnnest turns a LIST column into one row per element, e.g.
+// $__unnest(values) becomes unnest("values"). The other columns of the select
+// are repeated for each element.
+func macroUnnest(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return "unnest(" + quoteColumn(args[0]) + ")", nil
+}
+
+// macroQuoteIdent quotes a column or table name, e.g. $__quoteIdent(my col)
+// becomes "my col". Embedded double quotes are doubled.
+func macroQuoteIdent(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return quoteIdent(args[0]), nil
+}
+
+// parseInClauseValues splits a comma separated list of values, which may be
+// single quoted SQL literals.
+func parseInClauseValues(list string) ([]string, error) {
+	values := []string{}
+	rest := strings.TrimSpace(list)
+	for rest != "" {
+		var value string
+		if strings.HasPrefix(rest, "'") {
+			end := 1
+			var b strings.Builder
+			for {
+				i := strings.IndexByte(rest[end:], '\'')
+				if i < 0 {
+					return nil, fmt.Errorf("unterminated quoted value in %q", list)
+				}
+				b.WriteString(rest[end : end+i])
+				end += i + 1
+				if !strings.HasPrefix(rest[end:], "'") {
+					break
+				}
+				// An escaped quote.
+				b.WriteByte('\'')
+				end++
+			}
+			value = b.String()
+			rest = strings.TrimSpace(rest[end:])
+			if rest != "" && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("expected a comma after value %q in %q", value, list)
+			}
+		} else {
+			i := strings.IndexByte(rest, ',')
+			if i < 0 {
+				i = len(rest)
+			}
+			value = strings.TrimSpace(rest[:i])
+			rest = rest[i:]
+			if value == "" {
+				rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+				continue
+			}
+		}
+		values = append(values, value)
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return values, nil
+}
@@ -0,0 +1,2156 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+// queryFrame runs query against a fresh in-memory database and converts the
+// result with the given converters.
+func queryFrame(t *testing.T, query string, converters []sqlutil.Converter) *data.Frame {
+	t.Helper()
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	frame, err := sqlutil.FrameFromRows(rows, -1, converters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+// fieldValues returns the values of a nullable field with the pointers dereferenced.
+func fieldValues(t *testing.T, frame *data.Frame, name string) []any {
+	t.Helper()
+	field, _ := frame.FieldByName(name)
+	if field == nil {
+		t.Fatalf("field %s not found", name)
+	}
+	values := make([]any, field.Len())
+	for i := range values {
+		v, ok := field.ConcreteAt(i)
+		if ok {
+			values[i] = v
+		}
+	}
+	return values
+}
+
+func assertField(t *testing.T, frame *data.Frame, name string, fieldType data.FieldType, expected []any) {
+	t.Helper()
+	field, _ := frame.FieldByName(name)
+	if field == nil {
+		t.Fatalf("field %s not found", name)
+	}
+	if field.Type() != fieldType {
+		t.Errorf("expected field %s to be %s, got %s", name, fieldType, field.Type())
+	}
+	if got := fieldValues(t, frame, name); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected field %s to be %v, got %v", name, expected, got)
+	}
+}
+
+func macroQuery() *sqlutil.Query {
+	return &sqlutil.Query{
+		TimeRange: backend.TimeRange{
+			From: time.Date(2024, 3, 10, 11, 7, 42, 0, time.UTC),
+			To:   time.Date(2024, 3, 10, 12, 52, 3, 0, time.UTC),
+		},
+	}
+}
+
+func TestMacroTimeRounded(t *testing.T) {
+	tests := []struct {
+		name     string
+		macro    sqlutil.MacroFunc
+		args     []string
+		expected string
+	}{
+		{"from floors to minutes", macroTimeFromRounded, []string{"5m"}, "'2024-03-10T11:05:00Z'"},
+		{"from floors to hours", macroTimeFromRounded, []string{"1h"}, "'2024-03-10T11:00:00Z'"},
+		{"from floors to days", macroTimeFromRounded, []string{"1d"}, "'2024-03-10T00:00:00Z'"},
+		{"to ceils to minutes", macroTimeToRounded, []string{"5m"}, "'2024-03-10T12:55:00Z'"},
+		{"to ceils to hours", macroTimeToRounded, []string{" 1h "}, "'2024-03-10T13:00:00Z'"},
+		{"to ceils to days", macroTimeToRounded, []string{"1d"}, "'2024-03-11T00:00:00Z'"},
+		{"to on boundary is unchanged", macroTimeToRounded, []string{"1s"}, "'2024-03-10T12:52:03Z'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.macro(macroQuery(), tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMacroTimeRoundedTimezone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The time range of macroQuery in the dashboard timezone, on the day
+	// daylight saving time started in New York.
+	query := macroQuery()
+	query.TimeRange.From = query.TimeRange.From.In(newYork)
+	query.TimeRange.To = query.TimeRange.To.In(newYork)
+	tests := []struct {
+		name     string
+		macro    sqlutil.MacroFunc
+		args     []string
+		expected string
+	}{
+		{"from floors to local midnight", macroTimeFromRounded, []string{"1d"}, "'2024-03-10T00:00:00-05:00'"},
+		{"to ceils to local midnight", macroTimeToRounded, []string{"1d"}, "'2024-03-11T00:00:00-04:00'"},
+		{"from floors to local weeks", macroTimeFromRounded, []string{"1w"}, "'2024-03-04T00:00:00-05:00'"},
+		{"to ceils to local weeks", macroTimeToRounded, []string{"1w"}, "'2024-03-11T00:00:00-04:00'"},
+		{"hours stay on the hour", macroTimeFromRounded, []string{"1h"}, "'2024-03-10T07:00:00-04:00'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.macro(query, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// In UTC whole days round like time.Time.Truncate.
+	for _, interval := range []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 14 * 24 * time.Hour} {
+		from := macroQuery().TimeRange.From
+		if got, expected := floorTime(from, interval), from.Truncate(interval); !got.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", interval, expected, got)
+		}
+	}
+}
+
+func TestMacroTimeRoundedErrors(t *testing.T) {
+	for _, macro := range []sqlutil.MacroFunc{macroTimeFromRounded, macroTimeToRounded} {
+		if _, err := macro(macroQuery(), nil); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount without arguments, got %v", err)
+		}
+		if _, err := macro(macroQuery(), []string{"1m", "1h"}); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount with two arguments, got %v", err)
+		}
+		for _, interval := range []string{"", "5", "m", "0m", "1y", "-1m", "1.5h"} {
+			if _, err := macro(macroQuery(), []string{interval}); err == nil {
+				t.Errorf("expected an error for interval %q", interval)
+			}
+		}
+	}
+}
+
+func TestMacroTimeEpoch(t *testing.T) {
+	tests := []struct {
+		name     string
+		macro    sqlutil.MacroFunc
+		args     []string
+		expected string
+	}{
+		{"from in seconds by default", macroTimeFromEpoch, nil, "1710068862"},
+		{"from with empty parentheses", macroTimeFromEpoch, []string{""}, "1710068862"},
+		{"from in seconds", macroTimeFromEpoch, []string{"s"}, "1710068862"},
+		{"from in milliseconds", macroTimeFromEpoch, []string{" ms "}, "1710068862000"},
+		{"to in seconds", macroTimeToEpoch, []string{"s"}, "1710075123"},
+		{"to in milliseconds", macroTimeToEpoch, []string{"ms"}, "1710075123000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.macro(macroQuery(), tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	for _, macro := range []sqlutil.MacroFunc{macroTimeFromEpoch, macroTimeToEpoch} {
+		if _, err := macro(macroQuery(), []string{"s", "ms"}); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount with two arguments, got %v", err)
+		}
+		for _, unit := range []string{"us", "m", "MS", "seconds"} {
+			if _, err := macro(macroQuery(), []string{unit}); err == nil {
+				t.Errorf("expected an error for unit %q", unit)
+			}
+		}
+	}
+
+	// The plain macros keep their behavior next to the epoch variants.
+	got, err := sqlutil.Interpolate(macroQuery().WithSQL("$__timeFrom $__timeFromEpoch(ms) $__timeToEpoch"), (&DuckDBDriver{}).Macros())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "'2024-03-10T11:07:42Z' 1710068862000 1710075123"; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestUnsignedConverters(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(255::UTINYINT, 65535::USMALLINT, 4294967295::UINTEGER, 18446744073709551615::UBIGINT, 340282366920938463463374607431768211455::UHUGEINT),
+		(NULL, NULL, NULL, NULL, NULL)
+	) t(ut, us, ui, ub, uh)`, GetConverterList())
+
+	assertField(t, frame, "ut", data.FieldTypeNullableUint8, []any{uint8(255), nil})
+	assertField(t, frame, "us", data.FieldTypeNullableUint16, []any{uint16(65535), nil})
+	assertField(t, frame, "ui", data.FieldTypeNullableUint32, []any{uint32(4294967295), nil})
+	assertField(t, frame, "ub", data.FieldTypeNullableUint64, []any{uint64(18446744073709551615), nil})
+	assertField(t, frame, "uh", data.FieldTypeNullableString, []any{"340282366920938463463374607431768211455", nil})
+}
+
+func TestTimestampConverters(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(NULL::TIMESTAMPTZ, NULL::TIMESTAMP),
+		('1970-01-01 00:00:00+00'::TIMESTAMPTZ, '1970-01-01 00:00:00'::TIMESTAMP),
+		('2024-03-10 12:00:00.5+05:30'::TIMESTAMPTZ, '2024-03-10 12:00:00.5'::TIMESTAMP)
+	) t(tz, ts)`, GetConverterList())
+
+	assertField(t, frame, "tz", data.FieldTypeNullableTime, []any{
+		nil,
+		time.Unix(0, 0).UTC(),
+		time.Date(2024, 3, 10, 6, 30, 0, 500000000, time.UTC),
+	})
+	assertField(t, frame, "ts", data.FieldTypeNullableTime, []any{
+		nil,
+		time.Unix(0, 0).UTC(),
+		time.Date(2024, 3, 10, 12, 0, 0, 500000000, time.UTC),
+	})
+
+	// Values in a non-UTC location are normalized as well.
+	converter := converterFor(t, "TIMESTAMPTZ")
+	local := time.Date(2024, 3, 10, 12, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	got, err := converter.FrameConverter.ConverterFunc(&sql.NullTime{Time: local, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.(*time.Time); v.Location() != time.UTC || !v.Equal(local) {
+		t.Errorf("expected %v in UTC, got %v", local, v)
+	}
+}
+
+func TestTimestampPrecisionConverters(t *testing.T) {
+	// The same wall-clock instant lands on the same time in every precision.
+	frame := queryFrame(t, `SELECT
+		'2024-03-10 11:07:42'::TIMESTAMP_NS AS ns,
+		'2024-03-10 11:07:42'::TIMESTAMP_US AS us,
+		'2024-03-10 11:07:42'::TIMESTAMP_MS AS ms,
+		'2024-03-10 11:07:42'::TIMESTAMP_S AS s`, GetConverterList())
+	instant := time.Date(2024, 3, 10, 11, 7, 42, 0, time.UTC)
+	for _, name := range []string{"ns", "us", "ms", "s"} {
+		assertField(t, frame, name, data.FieldTypeNullableTime, []any{instant})
+	}
+
+	// Each precision keeps its fractional seconds.
+	frame = queryFrame(t, `SELECT * FROM (VALUES (
+		'2024-03-10 11:07:42.123456789'::TIMESTAMP_NS,
+		'2024-03-10 11:07:42.123456'::TIMESTAMP_US,
+		'2024-03-10 11:07:42.123'::TIMESTAMP_MS,
+		'2024-03-10 11:07:42.9'::TIMESTAMP_S
+	), (NULL, NULL, NULL, NULL)) t(ns, us, ms, s)`, GetConverterList())
+	assertField(t, frame, "ns", data.FieldTypeNullableTime, []any{instant.Add(123456789 * time.Nanosecond), nil})
+	assertField(t, frame, "us", data.FieldTypeNullableTime, []any{instant.Add(123456 * time.Microsecond), nil})
+	assertField(t, frame, "ms", data.FieldTypeNullableTime, []any{instant.Add(123 * time.Millisecond), nil})
+	assertField(t, frame, "s", data.FieldTypeNullableTime, []any{instant.Add(time.Second), nil})
+
+	// The end of the TIMESTAMP_NS range still fits, later times and infinity
+	// can't be sent to Grafana.
+	frame = queryFrame(t, `SELECT
+		'2262-04-11 23:47:16.854775'::TIMESTAMP_NS AS ns,
+		'9999-12-31 23:59:59'::TIMESTAMP_S AS s,
+		'infinity'::TIMESTAMP_MS AS ms,
+		'-infinity'::TIMESTAMP AS us`, GetConverterList())
+	assertField(t, frame, "ns", data.FieldTypeNullableTime, []any{time.Date(2262, 4, 11, 23, 47, 16, 854775000, time.UTC)})
+	assertField(t, frame, "s", data.FieldTypeNullableTime, []any{nil})
+	assertField(t, frame, "ms", data.FieldTypeNullableTime, []any{nil})
+	assertField(t, frame, "us", data.FieldTypeNullableTime, []any{nil})
+}
+
+func TestDateAndTimeConverters(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(NULL::DATE, NULL::TIME),
+		('2024-02-29'::DATE, '12:34:56.789'::TIME),
+		('1969-12-31'::DATE, '23:59:59.000001'::TIME),
+		('2024-03-10'::DATE, '08:00:00'::TIME)
+	) t(d, tod)`, GetConverterList())
+
+	assertField(t, frame, "d", data.FieldTypeNullableTime, []any{
+		nil,
+		time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+	})
+	assertField(t, frame, "tod", data.FieldTypeNullableString, []any{nil, "12:34:56.789", "23:59:59.000001", "08:00:00"})
+}
+
+func TestStructConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		({'name': 'a', 'value': 1, 'tags': ['x', 'y'], 'inner': {'ok': true, 'at': NULL::INTEGER}}),
+		(NULL),
+		({'name': 'it''s "quoted"', 'value': NULL, 'tags': [], 'inner': NULL})
+	) t(s)`, GetConverterList())
+
+	assertField(t, frame, "s", data.FieldTypeNullableString, []any{
+		`{"inner":{"at":null,"ok":true},"name":"a","tags":["x","y"],"value":1}`,
+		nil,
+		`{"inner":null,"name":"it's \"quoted\"","tags":[],"value":null}`,
+	})
+}
+
+func TestMapConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(MAP {'b': 2, 'a': 1}, MAP {10: 'ten', 2: 'two', -1: NULL}),
+		(NULL, NULL),
+		(MAP {}, MAP {})
+	) t(by_name, by_number)`, GetConverterList())
+
+	assertField(t, frame, "by_name", data.FieldTypeNullableString, []any{`{"a":1,"b":2}`, nil, `{}`})
+	assertField(t, frame, "by_number", data.FieldTypeNullableString, []any{
+		`[{"key":-1,"value":null},{"key":2,"value":"two"},{"key":10,"value":"ten"}]`,
+		nil,
+		// Empty maps have the shape of the others, chosen by the key type.
+		`[]`,
+	})
+}
+
+func TestListConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		([{'name': 'a', 'value': 1}, NULL, {'name': 'b', 'value': NULL}], [1, NULL, 3]::INTEGER[3]),
+		(NULL, NULL),
+		([], [4, 5, 6]::INTEGER[3])
+	) t(records, numbers)`, GetConverterList())
+
+	assertField(t, frame, "records", data.FieldTypeNullableString, []any{
+		`[{"name":"a","value":1},null,{"name":"b","value":null}]`,
+		nil,
+		`[]`,
+	})
+	assertField(t, frame, "numbers", data.FieldTypeNullableString, []any{`[1,null,3]`, nil, `[4,5,6]`})
+}
+
+func TestUnionConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT u FROM (VALUES
+		(1::UNION(num INTEGER, str VARCHAR)),
+		('a'::UNION(num INTEGER, str VARCHAR)),
+		(NULL)
+	) t(u)`, GetConverterList())
+
+	assertField(t, frame, "u", data.FieldTypeNullableString, []any{
+		`{"tag":"num","value":1}`,
+		`{"tag":"str","value":"a"}`,
+		nil,
+	})
+}
+
+func TestEnumConverter(t *testing.T) {
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TYPE mood AS ENUM ('sad', 'ok', 'happy')"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query(`SELECT * FROM (VALUES ('happy'::mood), (NULL), ('sad'::mood)) t(m)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	frame, err := sqlutil.FrameFromRows(rows, -1, GetConverterList()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertField(t, frame, "m", data.FieldTypeNullableString, []any{"happy", nil, "sad"})
+}
+
+func TestIntervalConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(INTERVAL '1 year 2 months 3 days 4 hours 5 minutes 6.789 seconds'),
+		(INTERVAL '500 milliseconds'),
+		(NULL),
+		(INTERVAL '-1 day -2 hours'),
+		(INTERVAL '30 hours'),
+		(INTERVAL '1 month'),
+		(INTERVAL '0 seconds'),
+		(TIMESTAMP '2024-03-10 12:00:00' - TIMESTAMP '2024-03-08 11:59:59.000001')
+	) t(i)`, GetConverterList())
+
+	// Same output as casting to VARCHAR in DuckDB.
+	assertField(t, frame, "i", data.FieldTypeNullableString, []any{
+		"1 year 2 months 3 days 04:05:06.789",
+		"00:00:00.5",
+		nil,
+		"-1 day -02:00:00",
+		"30:00:00",
+		"1 month",
+		"00:00:00",
+		"2 days 00:00:00.999999",
+	})
+}
+
+func TestBlobConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		('\xFF\x00\x80abc'::BLOB),
+		(NULL),
+		(''::BLOB)
+	) t(b)`, GetConverterList())
+
+	assertField(t, frame, "b", data.FieldTypeNullableString, []any{"/wCAYWJj", nil, ""})
+}
+
+func TestHugeIntAsFloat(t *testing.T) {
+	query := `SELECT * FROM (VALUES
+		(123::HUGEINT, 18446744073709551616::UHUGEINT),
+		(NULL, NULL),
+		((-170141183460469231731687303715884105728)::HUGEINT, 340282366920938463463374607431768211455::UHUGEINT)
+	) t(h, uh)`
+
+	frame := queryFrame(t, query, converterList(converterOptions{}))
+	assertField(t, frame, "h", data.FieldTypeNullableString, []any{"123", nil, "-170141183460469231731687303715884105728"})
+	assertField(t, frame, "uh", data.FieldTypeNullableString, []any{"18446744073709551616", nil, "340282366920938463463374607431768211455"})
+
+	frame = queryFrame(t, query, converterList(converterOptions{hugeIntAsFloat: true}))
+	assertField(t, frame, "h", data.FieldTypeNullableFloat64, []any{123.0, nil, -1.7014118346046923e38})
+	assertField(t, frame, "uh", data.FieldTypeNullableFloat64, []any{18446744073709551616.0, nil, 3.402823669209385e38})
+}
+
+func TestDecimalAsString(t *testing.T) {
+	query := `SELECT * FROM (VALUES
+		(1234567890123456789012345678.1234567891::DECIMAL(38,10), 1.5::DECIMAL(10,2)),
+		(NULL, NULL),
+		((-0.0000000001)::DECIMAL(38,10), 0::DECIMAL(10,2))
+	) t(big, small)`
+
+	frame := queryFrame(t, query, converterList(converterOptions{}))
+	assertField(t, frame, "big", data.FieldTypeNullableFloat64, []any{1.2345678901234568e27, nil, -1e-10})
+	assertField(t, frame, "small", data.FieldTypeNullableFloat64, []any{1.5, nil, 0.0})
+
+	frame = queryFrame(t, query, converterList(converterOptions{decimalAsString: true}))
+	assertField(t, frame, "big", data.FieldTypeNullableString, []any{"1234567890123456789012345678.1234567891", nil, "-0.0000000001"})
+	assertField(t, frame, "small", data.FieldTypeNullableString, []any{"1.50", nil, "0.00"})
+}
+
+func TestConvertersFollowSettings(t *testing.T) {
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": "", "hugeIntAsFloat": true}`),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, c := range driver.Converters() {
+		if c.InputTypeName == "HUGEINT" && c.FrameConverter.FieldType != data.FieldTypeNullableFloat64 {
+			t.Errorf("expected HUGEINT to be converted to float64, got %s", c.FrameConverter.FieldType)
+		}
+	}
+}
+
+func TestCustomConverters(t *testing.T) {
+	upper := sqlutil.Converter{
+		Name:          "upper case VARCHAR",
+		InputScanType: reflect.TypeOf(sql.NullString{}),
+		InputTypeName: "VARCHAR",
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableString,
+			ConverterFunc: func(in interface{}) (interface{}, error) {
+				v := in.(*sql.NullString)
+				if !v.Valid {
+					return (*string)(nil), nil
+				}
+				str := strings.ToUpper(v.String)
+				return &str, nil
+			},
+		},
+	}
+
+	frame := queryFrame(t, `SELECT * FROM (VALUES ('abc'), (NULL)) t(s)`, GetConverterList(upper))
+	assertField(t, frame, "s", data.FieldTypeNullableString, []any{"ABC", nil})
+
+	driver := &DuckDBDriver{CustomConverters: []sqlutil.Converter{upper}}
+	converters := driver.Converters()
+	if len(converters) != len(GetConverterList())+1 || converters[len(converters)-1].Name != upper.Name {
+		t.Error("expected the custom converter to be appended to the built-in converters")
+	}
+}
+
+func TestUnsignedConvertersAllNull(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(NULL::UTINYINT, NULL::USMALLINT, NULL::UINTEGER, NULL::UBIGINT),
+		(NULL, NULL, NULL, NULL)
+	) t(ut, us, ui, ub)`, GetConverterList())
+
+	assertField(t, frame, "ut", data.FieldTypeNullableUint8, []any{nil, nil})
+	assertField(t, frame, "us", data.FieldTypeNullableUint16, []any{nil, nil})
+	assertField(t, frame, "ui", data.FieldTypeNullableUint32, []any{nil, nil})
+	assertField(t, frame, "ub", data.FieldTypeNullableUint64, []any{nil, nil})
+}
+
+func TestNullUnsignedScan(t *testing.T) {
+	var n NullUnsigned[uint16]
+	if err := n.Scan(uint16(7)); err != nil || !n.Valid || n.Uint != 7 {
+		t.Errorf("expected a valid 7, got %+v, %v", n, err)
+	}
+	if err := n.Scan(nil); err != nil || n.Valid {
+		t.Errorf("expected NULL, got %+v, %v", n, err)
+	}
+	if err := n.Scan(int64(7)); err == nil || n.Valid {
+		t.Errorf("expected an error for a mismatched type, got %+v", n)
+	}
+}
+
+func TestJSONConverter(t *testing.T) {
+	// duckdb-go decodes JSON columns, the decoded values are encoded again.
+	// String scalars stay strings, also when they hold a number or JSON text.
+	// Casting to VARCHAR keeps the text.
+	frame := queryFrame(t, `SELECT j, j::VARCHAR AS text FROM (VALUES
+		('[1, "two", null, true]'::JSON),
+		('{"b": 12345678901234567890, "a": 1}'::JSON),
+		('"hello"'::JSON),
+		('"123"'::JSON),
+		('"[1]"'::JSON),
+		('123'::JSON),
+		(NULL)
+	) t(j)`, GetConverterList())
+	assertField(t, frame, "j", data.FieldTypeNullableString, []any{
+		`[1,"two",null,true]`, `{"a":1,"b":12345678901234567000}`, `"hello"`, `"123"`, `"[1]"`, `123`, nil,
+	})
+	assertField(t, frame, "text", data.FieldTypeNullableString, []any{
+		`[1, "two", null, true]`, `{"b": 12345678901234567890, "a": 1}`, `"hello"`, `"123"`, `"[1]"`, `123`, nil,
+	})
+}
+
+func converterFor(t *testing.T, typeName string) sqlutil.Converter {
+	t.Helper()
+	for _, c := range GetConverterList() {
+		if c.InputTypeName == typeName || (c.InputTypeRegex != nil && c.InputTypeRegex.MatchString(typeName)) {
+			return c
+		}
+	}
+	t.Fatalf("no converter for %s", typeName)
+	return sqlutil.Converter{}
+}
+
+func TestBitColumns(t *testing.T) {
+	// duckdb-go cannot scan BIT result columns, casting them to VARCHAR returns
+	// the bitstrings.
+	frame := queryFrame(t, `SELECT b::VARCHAR AS b FROM (VALUES
+		('1'::BIT),
+		('101010'::BIT),
+		('0000000011111111'::BIT),
+		(NULL)
+	) t(b)`, GetConverterList())
+	assertField(t, frame, "b", data.FieldTypeNullableString, []any{"1", "101010", "0000000011111111", nil})
+}
+
+func TestVarIntColumns(t *testing.T) {
+	// duckdb-go cannot scan VARINT (BIGNUM) result columns, casting them to
+	// VARCHAR returns the digits, also beyond the range of HUGEINT.
+	beyondHugeInt := "170141183460469231731687303715884105728"
+	frame := queryFrame(t, `SELECT v::VARCHAR AS v FROM (VALUES
+		('`+beyondHugeInt+`'::VARINT),
+		('-`+beyondHugeInt+`0'::VARINT),
+		(NULL)
+	) t(v)`, GetConverterList())
+	assertField(t, frame, "v", data.FieldTypeNullableString, []any{beyondHugeInt, "-" + beyondHugeInt + "0", nil})
+}
+
+func TestUUIDConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		('550e8400-e29b-41d4-a716-446655440000'::UUID),
+		(NULL),
+		('6BA7B810-9DAD-11D1-80B4-00C04FD430C8'::UUID)
+	) t(id)`, GetConverterList())
+
+	assertField(t, frame, "id", data.FieldTypeNullableString, []any{
+		"550e8400-e29b-41d4-a716-446655440000",
+		nil,
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+	})
+}
+
+func TestBooleanConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES (true), (NULL), (false)) t(b)`, GetConverterList())
+
+	assertField(t, frame, "b", data.FieldTypeNullableBool, []any{true, nil, false})
+}
+
+func TestGeometryColumns(t *testing.T) {
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("LOAD spatial"); err != nil {
+		t.Skipf("the spatial extension is not installed: %s", err)
+	}
+
+	// duckdb-go reports GEOMETRY columns as BLOBs in the internal format of
+	// the spatial extension, ST_AsText returns WKT.
+	rows, err := db.Query(`SELECT ST_AsText(geom) AS wkt, geom FROM (VALUES
+		(ST_Point(1, 2)),
+		(ST_GeomFromText('LINESTRING (0 0, 1 1)')),
+		(NULL)
+	) t(geom)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	frame, err := sqlutil.FrameFromRows(rows, -1, GetConverterList()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertField(t, frame, "wkt", data.FieldTypeNullableString, []any{"POINT (1 2)", "LINESTRING (0 0, 1 1)", nil})
+	if field, _ := frame.FieldByName("geom"); field == nil || field.Type() != data.FieldTypeNullableString {
+		t.Errorf("expected the GEOMETRY column as base64 BLOB strings, got %v", field)
+	}
+}
+
+func TestInt8Converter(t *testing.T) {
+	converter := converterFor(t, "INT8")
+	if converter.FrameConverter.FieldType != data.FieldTypeNullableInt64 {
+		t.Fatalf("expected a nullable int64 field, got %s", converter.FrameConverter.FieldType)
+	}
+	for _, tt := range []struct {
+		in       string
+		expected int64
+	}{
+		{"9223372036854775807", 9223372036854775807},
+		{"-9223372036854775808", -9223372036854775808},
+		{"40000", 40000},
+	} {
+		got, err := converter.FrameConverter.ConverterFunc(&sql.NullString{String: tt.in, Valid: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := got.(*int64); v == nil || *v != tt.expected {
+			t.Errorf("expected %d, got %v", tt.expected, got)
+		}
+	}
+
+	// DuckDB reports INT8 columns by their canonical BIGINT name.
+	frame := queryFrame(t, `SELECT * FROM (VALUES (9223372036854775807::INT8), (NULL)) t(i)`, GetConverterList())
+	assertField(t, frame, "i", data.FieldTypeNullableInt64, []any{int64(9223372036854775807), nil})
+}
+
+func TestFloat32Converter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES
+		(1.5::REAL, 1.5::DOUBLE),
+		(1.1::FLOAT4, 1.1::FLOAT8),
+		(NULL, NULL),
+		((-3.4028235e38)::FLOAT, (-1.7976931348623157e308)::DOUBLE)
+	) t(f, d)`, GetConverterList())
+	assertField(t, frame, "f", data.FieldTypeNullableFloat32, []any{float32(1.5), float32(1.1), nil, float32(-3.4028235e38)})
+	assertField(t, frame, "d", data.FieldTypeNullableFloat64, []any{1.5, 1.1, nil, -1.7976931348623157e308})
+}
+
+func TestTinyIntConverter(t *testing.T) {
+	frame := queryFrame(t, `SELECT * FROM (VALUES (127::TINYINT), ((-128)::TINYINT), (NULL)) t(i)`, GetConverterList())
+	assertField(t, frame, "i", data.FieldTypeNullableInt8, []any{int8(127), int8(-128), nil})
+}
+
+func TestMacroTimeFilter(t *testing.T) {
+	got, err := macroTimeFilter(macroQuery(), []string{" ts "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `ts >= '2024-03-10T11:07:42Z' AND ts <= '2024-03-10T12:52:03Z'`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	for _, args := range [][]string{nil, {""}, {"a", "b"}} {
+		if _, err := macroTimeFilter(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+}
+
+func TestMacroTimeGroup(t *testing.T) {
+	tests := []struct {
+		interval string
+		expected string
+	}{
+		{"500ms", `time_bucket(INTERVAL '500 milliseconds', ts)`},
+		{"30s", `time_bucket(INTERVAL '30 seconds', ts)`},
+		{"90s", `time_bucket(INTERVAL '90 seconds', ts)`},
+		{"5m", `time_bucket(INTERVAL '5 minutes', ts)`},
+		{"1h", `time_bucket(INTERVAL '1 hours', ts)`},
+		{"1d", `time_bucket(INTERVAL '1 days', ts)`},
+		{"2w", `time_bucket(INTERVAL '14 days', ts)`},
+	}
+	for _, tt := range tests {
+		got, err := macroTimeGroup(macroQuery(), []string{"ts", " " + tt.interval})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, got)
+		}
+	}
+
+	for _, args := range [][]string{nil, {"ts"}, {"", "1m"}, {"ts", "1m", "x"}} {
+		if _, err := macroTimeGroup(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+	if _, err := macroTimeGroup(macroQuery(), []string{"ts", "five minutes"}); err == nil || errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+		t.Errorf("expected an invalid interval error, got %v", err)
+	}
+}
+
+func TestMacroInterval(t *testing.T) {
+	query := macroQuery()
+	query.Interval = 15 * time.Second
+	got, err := macroInterval(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "INTERVAL '15 seconds'" {
+		t.Errorf("expected INTERVAL '15 seconds', got %s", got)
+	}
+
+	query.Interval = 1500 * time.Millisecond
+	if got, _ := macroInterval(query, []string{""}); got != "INTERVAL '1500 milliseconds'" {
+		t.Errorf("expected INTERVAL '1500 milliseconds', got %s", got)
+	}
+
+	if _, err := macroInterval(query, []string{"1m"}); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+		t.Errorf("expected ErrorBadArgumentCount, got %v", err)
+	}
+	if _, err := macroInterval(macroQuery(), nil); err == nil {
+		t.Error("expected an error when the query has no interval")
+	}
+}
+
+func TestClampInterval(t *testing.T) {
+	start := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		span          time.Duration
+		maxDataPoints int64
+		interval      time.Duration
+		expected      time.Duration
+	}{
+		{"no maxDataPoints", 24 * time.Hour, 0, time.Second, time.Second},
+		{"interval is wide enough", time.Hour, 1000, 5 * time.Second, 5 * time.Second},
+		{"1h over 1000 points", time.Hour, 1000, time.Second, 4 * time.Second},
+		{"24h over 500 points", 24 * time.Hour, 500, time.Second, 173 * time.Second},
+		{"7d over 1000 points", 7 * 24 * time.Hour, 1000, time.Minute, 605 * time.Second},
+		{"5m over 1000 points", 5 * time.Minute, 1000, 10 * time.Millisecond, 300 * time.Millisecond},
+		{"exact division", 10 * time.Minute, 600, time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &sqlutil.Query{
+				TimeRange:     backend.TimeRange{From: start, To: start.Add(tt.span)},
+				MaxDataPoints: tt.maxDataPoints,
+			}
+			if got := clampInterval(query, tt.interval); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMacroIntervalMaxDataPoints(t *testing.T) {
+	query := macroQuery()
+	query.TimeRange.To = query.TimeRange.From.Add(24 * time.Hour)
+	query.Interval = 10 * time.Second
+	query.MaxDataPoints = 500
+
+	if got, _ := macroInterval(query, nil); got != "INTERVAL '173 seconds'" {
+		t.Errorf("expected INTERVAL '173 seconds', got %s", got)
+	}
+	got, err := macroTimeGroup(query, []string{"ts", "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `time_bucket(INTERVAL '173 seconds', ts)`; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	// Wider intervals are kept.
+	if got, _ := macroTimeGroup(query, []string{"ts", "1h"}); got != `time_bucket(INTERVAL '1 hours', ts)` {
+		t.Errorf("expected the 1h interval to be kept, got %s", got)
+	}
+}
+
+func TestMacroUnixEpochFilter(t *testing.T) {
+	got, err := macroUnixEpochFilter(macroQuery(), []string{"epoch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `epoch >= 1710068862 AND epoch <= 1710075123`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	for _, args := range [][]string{nil, {" "}, {"a", "b"}} {
+		if _, err := macroUnixEpochFilter(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+}
+
+func TestMacroUnixEpochGroup(t *testing.T) {
+	got, err := macroUnixEpochGroup(macroQuery(), []string{"epoch", "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `(epoch // 300) * 300`; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	for _, args := range [][]string{nil, {"epoch"}, {"epoch", "1m", "1h"}} {
+		if _, err := macroUnixEpochGroup(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+	for _, interval := range []string{"500ms", "abc"} {
+		if _, err := macroUnixEpochGroup(macroQuery(), []string{"epoch", interval}); err == nil {
+			t.Errorf("expected an error for interval %q", interval)
+		}
+	}
+}
+
+func TestConnectAppliesPoolSettings(t *testing.T) {
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path":"", "maxOpenConns": 4, "maxIdleConns": 1, "connMaxLifetimeSeconds": 60}`),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if stats := db.Stats(); stats.MaxOpenConnections != 4 {
+		t.Errorf("expected 4 max open connections, got %d", stats.MaxOpenConnections)
+	}
+
+	conns := []*sql.Conn{}
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if stats := db.Stats(); stats.Idle != 1 {
+		t.Errorf("expected 1 idle connection, got %d", stats.Idle)
+	}
+}
+
+func TestConnectDefaultPoolSettings(t *testing.T) {
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path":""}`),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if stats := db.Stats(); stats.MaxOpenConnections != 0 {
+		t.Errorf("expected unlimited open connections, got %d", stats.MaxOpenConnections)
+	}
+}
+
+func TestConnectorDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		config   models.PluginSettings
+		expected string
+	}{
+		{"in-memory", "", models.PluginSettings{}, ""},
+		{"file", "/data/db.duckdb", models.PluginSettings{}, "/data/db.duckdb"},
+		{"read-only file", "/data/db.duckdb", models.PluginSettings{ReadOnly: true}, "/data/db.duckdb?access_mode=READ_ONLY"},
+		{"read-only ignored in-memory", "", models.PluginSettings{ReadOnly: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectorDSN(tt.path, &tt.config); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// createDatabaseFile creates a DuckDB file running the given setup statements.
+func createDatabaseFile(t *testing.T, setup string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.duckdb")
+	connector, err := duckdb.NewConnector(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	if _, err := db.Exec(setup); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConnectReadOnly(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{"path": %q, "readOnly": true}`, path)),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var x int
+	if err := db.QueryRow("SELECT x FROM t").Scan(&x); err != nil || x != 1 {
+		t.Fatalf("expected to read from the database, got %d, %v", x, err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (2)"); err == nil {
+		t.Error("expected writes to fail on a read-only database")
+	}
+}
+
+func TestBootQueriesExtensions(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	config := &models.PluginSettings{
+		Extensions: []string{"httpfs", " spatial ", "", "httpfs", "motherduck"},
+		Secrets:    &models.SecretPluginSettings{},
+	}
+	expected := []string{
+		"INSTALL 'httpfs';", "LOAD 'httpfs';",
+		"INSTALL 'spatial';", "LOAD 'spatial';",
+		"INSTALL 'motherduck';", "LOAD 'motherduck';",
+	}
+	if got, _ := bootQueries(config); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	// motherduck is installed by the MotherDuck setup and not repeated.
+	config.Path = "md:my_db"
+	config.Secrets.MotherDuckToken = "token"
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"INSTALL 'motherduck';", "LOAD 'motherduck';",
+		"SET motherduck_token='token';",
+		"ATTACH IF NOT EXISTS 'md:my_db' (TYPE motherduck);",
+		"INSTALL 'httpfs';", "LOAD 'httpfs';",
+		"INSTALL 'spatial';", "LOAD 'spatial';",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestBootQueriesExtensionRepository(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	config := &models.PluginSettings{
+		Path:                "md:my_db",
+		Extensions:          []string{"httpfs", "Spatial"},
+		ExtensionRepository: "https://extensions.example.com/it's",
+		ExtensionVersions:   map[string]string{"motherduck": "v1.4.4", "spatial ": "abc'123", "httpfs": " "},
+		Secrets:             &models.SecretPluginSettings{MotherDuckToken: "token"},
+	}
+	expected := []string{
+		"SET custom_extension_repository='https://extensions.example.com/it''s';",
+		"INSTALL 'motherduck' VERSION 'v1.4.4';", "LOAD 'motherduck';",
+		"SET motherduck_token='token';",
+		"ATTACH IF NOT EXISTS 'md:my_db' (TYPE motherduck);",
+		"INSTALL 'httpfs';", "LOAD 'httpfs';",
+		"INSTALL 'Spatial' VERSION 'abc''123';", "LOAD 'Spatial';",
+	}
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	// The versioned INSTALL is still retried like the plain one.
+	if !isMotherDuckBootQuery("INSTALL 'motherduck' VERSION 'v1.4.4';") {
+		t.Error("expected the versioned MotherDuck INSTALL to be a MotherDuck boot query")
+	}
+
+	for _, repository := range []string{"/opt/duckdb/extensions", "http://mirror:8080/duckdb", "s3://bucket/extensions"} {
+		config := &models.PluginSettings{ExtensionRepository: repository, Secrets: &models.SecretPluginSettings{}}
+		if _, err := bootQueries(config); err != nil {
+			t.Errorf("expected %s to be accepted, got %v", repository, err)
+		}
+	}
+	for _, repository := range []string{"extensions", "ftp://mirror/duckdb", "https://", "https//mirror"} {
+		config := &models.PluginSettings{ExtensionRepository: repository, Secrets: &models.SecretPluginSettings{}}
+		var configErr *ConfigError
+		if _, err := bootQueries(config); !errors.As(err, &configErr) {
+			t.Errorf("expected a ConfigError for %s, got %v", repository, err)
+		}
+	}
+}
+
+func TestBootQueriesAutoloadExtensions(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	config := &models.PluginSettings{Secrets: &models.SecretPluginSettings{}}
+	if got, err := bootQueries(config); err != nil || len(got) != 0 {
+		t.Fatalf("expected DuckDB's defaults to be kept when unset, got %q, %v", got, err)
+	}
+
+	enabled, disabled := true, false
+	tests := []struct {
+		autoinstall *bool
+		autoload    *bool
+		expected    []string
+	}{
+		{&disabled, nil, []string{"SET autoinstall_known_extensions=false;"}},
+		{nil, &enabled, []string{"SET autoload_known_extensions=true;"}},
+		{&enabled, &disabled, []string{"SET autoinstall_known_extensions=true;", "SET autoload_known_extensions=false;"}},
+	}
+	for _, test := range tests {
+		config := &models.PluginSettings{
+			AutoinstallKnownExtensions: test.autoinstall,
+			AutoloadKnownExtensions:    test.autoload,
+			Secrets:                    &models.SecretPluginSettings{},
+		}
+		got, err := bootQueries(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+
+	// The settings apply to the extensions installed on boot, after the
+	// repository is set.
+	config = &models.PluginSettings{
+		Extensions:                 []string{"httpfs"},
+		ExtensionRepository:        "/opt/duckdb/extensions",
+		AutoinstallKnownExtensions: &disabled,
+		AutoloadKnownExtensions:    &disabled,
+		Secrets:                    &models.SecretPluginSettings{},
+	}
+	expected := []string{
+		"SET custom_extension_repository='/opt/duckdb/extensions';",
+		"SET autoinstall_known_extensions=false;",
+		"SET autoload_known_extensions=false;",
+		"INSTALL 'httpfs';", "LOAD 'httpfs';",
+	}
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": "", "autoinstallKnownExtensions": false, "autoloadKnownExtensions": false}`),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var autoinstall, autoload bool
+	if err := db.QueryRow("SELECT current_setting('autoinstall_known_extensions'), current_setting('autoload_known_extensions')").Scan(&autoinstall, &autoload); err != nil {
+		t.Fatal(err)
+	}
+	if autoinstall || autoload {
+		t.Errorf("expected both settings to be disabled, got %v and %v", autoinstall, autoload)
+	}
+}
+
+func TestBootQueriesAttachments(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	config := &models.PluginSettings{
+		Attachments: []models.Attachment{
+			{Alias: "sales", Path: "/data/sales.duckdb", ReadOnly: true},
+			{Alias: "legacy", Path: "/data/it's.sqlite", Type: "sqlite"},
+			{Alias: "cloud", Path: "md:analytics", Type: "motherduck"},
+		},
+		Secrets: &models.SecretPluginSettings{},
+	}
+	expected := []string{
+		"INSTALL 'motherduck';", "LOAD 'motherduck';",
+		"ATTACH IF NOT EXISTS '/data/sales.duckdb' AS \"sales\" (READ_ONLY);",
+		"ATTACH IF NOT EXISTS '/data/it''s.sqlite' AS \"legacy\" (TYPE sqlite);",
+		"ATTACH IF NOT EXISTS 'md:analytics' AS \"cloud\" (TYPE motherduck);",
+	}
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	for _, attachment := range []models.Attachment{
+		{Alias: "a", Path: " "},
+		{Alias: "a", Path: "/data/a.db", Type: "sqlite); DROP TABLE x; --"},
+	} {
+		config.Attachments = []models.Attachment{attachment}
+		var configErr *ConfigError
+		if _, err := bootQueries(config); !errors.As(err, &configErr) {
+			t.Errorf("expected a ConfigError for %+v, got %v", attachment, err)
+		}
+	}
+}
+
+func TestConnectAttachments(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{"path": "", "attachments": [{"alias": "other", "path": %q, "readOnly": true}]}`, path)),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var x int
+	if err := db.QueryRow("SELECT x FROM other.t").Scan(&x); err != nil || x != 1 {
+		t.Fatalf("expected to read from the attached database, got %d, %v", x, err)
+	}
+	if _, err := db.Exec("INSERT INTO other.t VALUES (2)"); err == nil {
+		t.Error("expected writes to the read-only attachment to fail")
+	}
+}
+
+func TestSearchPathQuery(t *testing.T) {
+	tests := []struct {
+		searchPath string
+		expected   string
+	}{
+		{"", ""},
+		{" ", ""},
+		{"sales", "SET search_path='sales';"},
+		{" sales , analytics.reports ", "SET search_path='sales,analytics.reports';"},
+		{`"my db".main,"it's"`, `SET search_path='"my db".main,"it''s"';`},
+		{`"a,b"."c.d"`, `SET search_path='"a,b"."c.d"';`},
+		{`"say ""hi"""`, `SET search_path='"say ""hi"""';`},
+	}
+	for _, tt := range tests {
+		got, err := searchPathQuery(&models.PluginSettings{SearchPath: tt.searchPath})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.searchPath, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.searchPath, tt.expected, got)
+		}
+	}
+
+	for _, invalid := range []string{"a.b.c", "sales,", "my db", "sales'; DROP TABLE t; --", `"unterminated`, `""`, "1db"} {
+		var configErr *ConfigError
+		if _, err := searchPathQuery(&models.PluginSettings{SearchPath: invalid}); !errors.As(err, &configErr) {
+			t.Errorf("expected a ConfigError for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestConnectSearchPath(t *testing.T) {
+	sales := createDatabaseFile(t, "CREATE TABLE orders AS SELECT 1 AS x")
+	reports := createDatabaseFile(t, "CREATE SCHEMA reports; CREATE TABLE reports.daily AS SELECT 2 AS y")
+
+	// The catalog attached by Init SQL is in the search path too.
+	settings, err := json.Marshal(map[string]any{
+		"path":        "",
+		"attachments": []map[string]any{{"alias": "sales", "path": sales, "readOnly": true}},
+		"initSql":     "ATTACH " + quoteLiteral(reports) + ` AS "my reports" (READ_ONLY);`,
+		"searchPath":  `sales, "my reports".reports`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: settings}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Every connection of the pool resolves unqualified names.
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var x, y int
+		if err := conn.QueryRowContext(ctx, "SELECT x, y FROM orders, daily").Scan(&x, &y); err != nil || x != 1 || y != 2 {
+			t.Fatalf("connection %d: expected to read unqualified tables, got %d, %d, %v", i, x, y, err)
+		}
+	}
+}
+
+func TestBootQueriesCloudSecrets(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	tests := []struct {
+		name     string
+		secrets  models.SecretPluginSettings
+		expected []string
+	}{
+		{
+			name:     "none",
+			secrets:  models.SecretPluginSettings{CloudRegion: "eu-west-1"},
+			expected: []string{},
+		},
+		{
+			name:    "s3",
+			secrets: models.SecretPluginSettings{CloudProvider: "S3", CloudKeyID: "AKIA", CloudSecret: "s3cr3t", CloudRegion: "eu-west-1", CloudEndpoint: "minio:9000"},
+			expected: []string{
+				"INSTALL 'httpfs';", "LOAD 'httpfs';",
+				"CREATE OR REPLACE SECRET grafana_cloud (TYPE S3, KEY_ID 'AKIA', SECRET 's3cr3t', REGION 'eu-west-1', ENDPOINT 'minio:9000');",
+			},
+		},
+		{
+			name:    "s3 by default",
+			secrets: models.SecretPluginSettings{CloudKeyID: "AKIA", CloudSecret: "s3cr3t"},
+			expected: []string{
+				"INSTALL 'httpfs';", "LOAD 'httpfs';",
+				"CREATE OR REPLACE SECRET grafana_cloud (TYPE S3, KEY_ID 'AKIA', SECRET 's3cr3t');",
+			},
+		},
+		{
+			name:    "gcs",
+			secrets: models.SecretPluginSettings{CloudProvider: "gcs", CloudKeyID: "GOOG", CloudSecret: "hmac", CloudRegion: "ignored"},
+			expected: []string{
+				"INSTALL 'httpfs';", "LOAD 'httpfs';",
+				"CREATE OR REPLACE SECRET grafana_cloud (TYPE GCS, KEY_ID 'GOOG', SECRET 'hmac');",
+			},
+		},
+		{
+			name:    "azure connection string",
+			secrets: models.SecretPluginSettings{CloudProvider: "azure", CloudSecret: "AccountName=acc;AccountKey=key"},
+			expected: []string{
+				"INSTALL 'azure';", "LOAD 'azure';",
+				"CREATE OR REPLACE SECRET grafana_cloud (TYPE AZURE, CONNECTION_STRING 'AccountName=acc;AccountKey=key');",
+			},
+		},
+		{
+			name:    "azure account name",
+			secrets: models.SecretPluginSettings{CloudProvider: "azure", CloudKeyID: "acc"},
+			expected: []string{
+				"INSTALL 'azure';", "LOAD 'azure';",
+				"CREATE OR REPLACE SECRET grafana_cloud (TYPE AZURE, ACCOUNT_NAME 'acc');",
+			},
+		},
+		{
+			name:    "escaped",
+			secrets: models.SecretPluginSettings{CloudKeyID: "it's", CloudSecret: "x'); DROP TABLE t; --"},
+			expected: []string{
+				"INSTALL 'httpfs';", "LOAD 'httpfs';",
+				"CREATE OR REPLACE SECRET grafana_cloud (TYPE S3, KEY_ID 'it''s', SECRET 'x''); DROP TABLE t; --');",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bootQueries(&models.PluginSettings{Secrets: &tt.secrets})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	var configErr *ConfigError
+	_, err := bootQueries(&models.PluginSettings{Secrets: &models.SecretPluginSettings{CloudProvider: "ftp", CloudKeyID: "a"}})
+	if !errors.As(err, &configErr) {
+		t.Errorf("expected a ConfigError for an unknown provider, got %v", err)
+	}
+}
+
+func TestBootQueriesResourceLimits(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	config := &models.PluginSettings{Secrets: &models.SecretPluginSettings{}}
+	if got, err := bootQueries(config); err != nil || len(got) != 0 {
+		t.Fatalf("expected no boot queries by default, got %q, %v", got, err)
+	}
+
+	config.MemoryLimit = "4GB"
+	config.Threads = 2
+	config.TempDirectory = "/var/lib/grafana/duckdb-tmp/it's"
+	expected := []string{"SET memory_limit='4GB';", "SET threads=2;", "SET temp_directory='/var/lib/grafana/duckdb-tmp/it''s';"}
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	for _, invalid := range []models.PluginSettings{
+		{MemoryLimit: "lots"},
+		{MemoryLimit: "4GB'; DROP TABLE x; --"},
+		{Threads: -1},
+	} {
+		invalid.Secrets = &models.SecretPluginSettings{}
+		var configErr *ConfigError
+		if _, err := bootQueries(&invalid); !errors.As(err, &configErr) {
+			t.Errorf("expected a ConfigError for %+v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestBootQueriesEscaping(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "/var/lib/grafana's data")
+
+	config := &models.PluginSettings{
+		Path:    "md:it's_db",
+		Secrets: &models.SecretPluginSettings{MotherDuckToken: "abc'; DROP TABLE x; --\\"},
+	}
+	expected := []string{
+		"SET home_directory='/var/lib/grafana''s data';",
+		"SET extension_directory='/var/lib/grafana''s data/.duckdb/extensions';",
+		"SET secret_directory='/var/lib/grafana''s data/.duckdb/stored_secrets';",
+		"INSTALL 'motherduck';", "LOAD 'motherduck';",
+		"SET motherduck_token='abc''; DROP TABLE x; --\\';",
+		"ATTACH IF NOT EXISTS 'md:it''s_db' (TYPE motherduck);",
+	}
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestBootQueriesMotherDuckAlias(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	tests := []struct {
+		name     string
+		alias    string
+		expected string
+	}{
+		{"default", "", "ATTACH IF NOT EXISTS 'md:my_db' (TYPE motherduck);"},
+		{"blank", "  ", "ATTACH IF NOT EXISTS 'md:my_db' (TYPE motherduck);"},
+		{"alias", "analytics", `ATTACH IF NOT EXISTS 'md:my_db' AS "analytics" (TYPE motherduck);`},
+		{"escaped alias", `my "db"`, `ATTACH IF NOT EXISTS 'md:my_db' AS "my ""db""" (TYPE motherduck);`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &models.PluginSettings{
+				Path:            "md:my_db",
+				MotherDuckAlias: tt.alias,
+				Secrets:         &models.SecretPluginSettings{MotherDuckToken: "token"},
+			}
+			got, err := bootQueries(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{
+				"INSTALL 'motherduck';", "LOAD 'motherduck';",
+				"SET motherduck_token='token';",
+				tt.expected,
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestConnectEscapesDataPath(t *testing.T) {
+	homePath := filepath.Join(t.TempDir(), "it's home")
+	t.Setenv("GF_PATHS_DATA", homePath)
+
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": ""}`),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got string
+	if err := db.QueryRow("SELECT current_setting('home_directory')").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != homePath {
+		t.Errorf("expected %q, got %q", homePath, got)
+	}
+}
+
+func TestSettingsQueryTimeout(t *testing.T) {
+	driver := &DuckDBDriver{}
+	for jsonData, expected := range map[string]time.Duration{
+		`{}`:                       30 * time.Second,
+		`{"queryTimeout": "5m"}`:   5 * time.Minute,
+		`{"queryTimeout": "90s"}`:  90 * time.Second,
+		`{"queryTimeout": "soon"}`: 30 * time.Second,
+		// sqlds gets the longest timeout a query may ask for.
+		`{"queryTimeout": "1m", "maxQueryTimeout": "10m"}`: 10 * time.Minute,
+		`{"queryTimeout": "1m", "maxQueryTimeout": "10s"}`: time.Minute,
+	} {
+		settings := driver.Settings(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)})
+		if settings.Timeout != expected {
+			t.Errorf("%s: expected %v, got %v", jsonData, expected, settings.Timeout)
+		}
+	}
+
+	for _, invalid := range []string{"soon", "-1s", "0"} {
+		for _, key := range []string{"queryTimeout", "maxQueryTimeout"} {
+			_, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+				JSONData: []byte(`{"` + key + `": "` + invalid + `"}`),
+			}, nil)
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Errorf("%s %s: expected a ConfigError, got %v", key, invalid, err)
+			}
+		}
+	}
+}
+
+func TestRunBootQueriesCancelled(t *testing.T) {
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connector.Close()
+	conn, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	execer := conn.(driver.ExecerContext)
+
+	config := &models.PluginSettings{InitSql: "CREATE TABLE from_init AS SELECT 1 AS x;"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = runBootQueries(ctx, execer, []string{"CREATE TABLE from_boot AS SELECT 1 AS x;"}, config)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Nothing ran, so the tables can still be created.
+	if err := runBootQueries(context.Background(), execer, []string{"CREATE TABLE from_boot AS SELECT 1 AS x;"}, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingExecer fails the statements in failures until their errors are used
+// up and records every statement it runs.
+type failingExecer struct {
+	failures map[string][]error
+	executed []string
+}
+
+func (e *failingExecer) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e.executed = append(e.executed, query)
+	if errs := e.failures[query]; len(errs) > 0 {
+		e.failures[query] = errs[1:]
+		return nil, errs[0]
+	}
+	return driver.ResultNoRows, nil
+}
+
+func TestRunBootQueriesMotherDuckRetry(t *testing.T) {
+	backoff := motherDuckRetryBackoff
+	motherDuckRetryBackoff = time.Millisecond
+	t.Cleanup(func() { motherDuckRetryBackoff = backoff })
+
+	attach := "ATTACH IF NOT EXISTS 'md:my_db' (TYPE motherduck);"
+	queries := []string{
+		"INSTALL 'motherduck';", "LOAD 'motherduck';",
+		"SET motherduck_token='token';",
+		attach,
+	}
+	count := func(executed []string, query string) int {
+		n := 0
+		for _, q := range executed {
+			if q == query {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("transient error is retried", func(t *testing.T) {
+		execer := &failingExecer{failures: map[string][]error{
+			attach: {errors.New("IO Error: connection reset by peer"), errors.New("HTTP 503 Service Unavailable")},
+		}}
+		if err := runBootQueries(context.Background(), execer, queries, &models.PluginSettings{}); err != nil {
+			t.Fatal(err)
+		}
+		if n := count(execer.executed, attach); n != 3 {
+			t.Errorf("expected the ATTACH to run 3 times, ran %d times", n)
+		}
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		failures := make([]error, motherDuckRetries+2)
+		for i := range failures {
+			failures[i] = errors.New("IO Error: request timed out")
+		}
+		execer := &failingExecer{failures: map[string][]error{attach: failures}}
+		err := runBootQueries(context.Background(), execer, queries, &models.PluginSettings{})
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("expected the timeout error, got %v", err)
+		}
+		if n := count(execer.executed, attach); n != motherDuckRetries+1 {
+			t.Errorf("expected the ATTACH to run %d times, ran %d times", motherDuckRetries+1, n)
+		}
+	})
+
+	t.Run("bad token is not retried", func(t *testing.T) {
+		execer := &failingExecer{failures: map[string][]error{
+			attach: {errors.New("Invalid Input Error: Your request is not authenticated. Please check your MotherDuck token. (Jwt is not in the form of Header.Payload.Signature with two dots and 3 sections)")},
+		}}
+		err := runBootQueries(context.Background(), execer, queries, &models.PluginSettings{})
+		if err == nil || !strings.Contains(err.Error(), "token") {
+			t.Fatalf("expected the token error, got %v", err)
+		}
+		if n := count(execer.executed, attach); n != 1 {
+			t.Errorf("expected the ATTACH to run once, ran %d times", n)
+		}
+	})
+
+	t.Run("other statements are not retried", func(t *testing.T) {
+		query := "CREATE TABLE t AS SELECT 1;"
+		execer := &failingExecer{failures: map[string][]error{query: {errors.New("IO Error: connection reset by peer")}}}
+		if err := runBootQueries(context.Background(), execer, []string{query}, &models.PluginSettings{}); err == nil {
+			t.Fatal("expected an error")
+		}
+		if n := count(execer.executed, query); n != 1 {
+			t.Errorf("expected the statement to run once, ran %d times", n)
+		}
+	})
+}
+
+func TestBootQueryErrors(t *testing.T) {
+	t.Run("init sql failure names the statement", func(t *testing.T) {
+		config := &models.PluginSettings{InitSql: "CREATE TABLE a AS SELECT 1;\nCREATE TABLE b AS SELECT * FROM missing;"}
+		execer := &failingExecer{failures: map[string][]error{
+			"CREATE TABLE b AS SELECT * FROM missing": {errors.New("Catalog Error: Table with name missing does not exist!")},
+		}}
+		err := runBootQueries(context.Background(), execer, nil, config)
+		expected := "boot query failed [InitSql statement 2: CREATE TABLE b AS SELECT * FROM missing]: Catalog Error: Table with name missing does not exist!"
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	})
+
+	t.Run("token is not leaked", func(t *testing.T) {
+		token := "eyJhbGciOiJIUzI1NiJ9.secret-token.signature"
+		config := &models.PluginSettings{Secrets: &models.SecretPluginSettings{MotherDuckToken: token}}
+		query := "SET motherduck_token=" + quoteLiteral(token) + ";"
+		cause := errors.New("Invalid Input Error: could not set motherduck_token to '" + token + "'")
+		execer := &failingExecer{failures: map[string][]error{query: {cause}}}
+		err := runBootQueries(context.Background(), execer, []string{"INSTALL 'motherduck';", query}, config)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if strings.Contains(err.Error(), token) {
+			t.Errorf("expected the token to be redacted, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "boot query failed [SET motherduck_token=<redacted>]") {
+			t.Errorf("expected the statement in the error, got %v", err)
+		}
+	})
+
+	t.Run("cause is kept", func(t *testing.T) {
+		cause := errors.New("IO Error: extension not found")
+		execer := &failingExecer{failures: map[string][]error{"LOAD 'spatial';": {cause}}}
+		err := runBootQueries(context.Background(), execer, []string{"LOAD 'spatial';"}, &models.PluginSettings{})
+		if !errors.Is(err, cause) || !strings.Contains(err.Error(), "[LOAD 'spatial']") {
+			t.Errorf("expected the wrapped LOAD error, got %v", err)
+		}
+	})
+}
+
+func TestDescribeStatement(t *testing.T) {
+	for query, expected := range map[string]string{
+		"LOAD 'httpfs';": "LOAD 'httpfs'",
+		"ATTACH IF NOT EXISTS 'md:my_db?motherduck_token=abc' AS \"md\" (TYPE motherduck);": "ATTACH IF NOT EXISTS 'md:my_db?motherduck_token=<redacted>' AS \"md\" (TYPE motherduck)",
+		"SET s3_secret_access_key='abc';":                                              "SET s3_secret_access_key=<redacted>",
+		"CREATE OR REPLACE SECRET grafana_cloud (TYPE s3, KEY_ID 'id', SECRET 'abc');": "CREATE OR REPLACE SECRET grafana_cloud (<redacted>)",
+		"-- views\nCREATE VIEW v AS\n  SELECT *\n  FROM t":                             "CREATE VIEW v AS SELECT * FROM t",
+		"SELECT '" + strings.Repeat("x", 150) + "'":                                    "SELECT '" + strings.Repeat("x", 112) + "...",
+	} {
+		if got := describeStatement(query); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, query, got)
+		}
+	}
+}
+
+func TestIsTransientMotherDuckError(t *testing.T) {
+	for msg, expected := range map[string]bool{
+		"IO Error: connection refused":                     true,
+		"HTTP Error: 502 Bad Gateway":                      true,
+		"Catalog Error: deadline exceeded":                 true,
+		"service temporarily unavailable":                  true,
+		"Invalid Input Error: invalid MotherDuck token":    false,
+		"HTTP 401 Unauthorized":                            false,
+		"network error: 403 Forbidden":                     false,
+		"Catalog Error: Database \"my_db\" does not exist": false,
+	} {
+		if got := isTransientMotherDuckError(errors.New(msg)); got != expected {
+			t.Errorf("%q: expected %v, got %v", msg, expected, got)
+		}
+	}
+}
+
+func TestSettingsRetries(t *testing.T) {
+	driver := &DuckDBDriver{}
+
+	settings := driver.Settings(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{}`)})
+	if settings.Retries != 3 || settings.Pause != 100 || len(settings.RetryOn) != 0 {
+		t.Errorf("expected the default retry settings, got %d %d %q", settings.Retries, settings.Pause, settings.RetryOn)
+	}
+
+	settings = driver.Settings(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"retryOn": ["HTTP Error", " ", "Connection reset"], "retries": 0, "pause": 2}`),
+	})
+	if expected := []string{"HTTP Error", "Connection reset"}; !reflect.DeepEqual(settings.RetryOn, expected) {
+		t.Errorf("expected %q, got %q", expected, settings.RetryOn)
+	}
+	if settings.Retries != 0 || settings.Pause != 2 {
+		t.Errorf("expected 0 retries with a 2s pause, got %d %d", settings.Retries, settings.Pause)
+	}
+
+	for _, invalid := range []string{`{"retries": -1}`, `{"pause": -1}`} {
+		_, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(invalid)}, nil)
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			t.Errorf("%s: expected a ConfigError, got %v", invalid, err)
+		}
+	}
+}
+
+func TestBootQueriesDuckDBSettings(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	config := &models.PluginSettings{
+		Threads:    4,
+		Extensions: []string{"httpfs"},
+		DuckDBSettings: map[string]string{
+			"s3_region":     "eu-west-1",
+			"TimeZone":      "UTC",
+			"s3_secret_key": "it's'secret",
+		},
+		Secrets: &models.SecretPluginSettings{},
+	}
+	expected := []string{
+		"SET threads=4;",
+		"INSTALL 'httpfs';", "LOAD 'httpfs';",
+		"SET TimeZone='UTC';",
+		"SET s3_region='eu-west-1';",
+		"SET s3_secret_key='it''s''secret';",
+	}
+	got, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	for _, key := range []string{"", "threads=1; DROP TABLE x; --", "s3 region", "1threads"} {
+		config.DuckDBSettings = map[string]string{key: "1"}
+		var configErr *ConfigError
+		if _, err := bootQueries(config); !errors.As(err, &configErr) {
+			t.Errorf("%q: expected a ConfigError, got %v", key, err)
+		}
+	}
+}
+
+func TestConnectDuckDBSettings(t *testing.T) {
+	driver := &DuckDBDriver{}
+	db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": "", "duckdbSettings": {"default_null_order": "nulls_first_on_asc_last_on_desc"}}`),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var got string
+	if err := db.QueryRow("SELECT current_setting('default_null_order')").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.EqualFold(got, "nulls_first_on_asc_last_on_desc") {
+		t.Errorf("expected the setting to be applied, got %q", got)
+	}
+}
+
+func TestIsSessionStatement(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"CREATE TEMP VIEW v AS SELECT 1", true},
+		{"create or replace temporary macro m(x) AS x + 1", true},
+		{"SET search_path = 'main'", true},
+		{"SET VARIABLE x = 1", true},
+		{"RESET search_path", true},
+		{"USE other", true},
+		{"-- comment; with semicolon\n/* block */ CREATE TEMP TABLE t(i INTEGER)", true},
+		{"SET GLOBAL threads = 2", false},
+		{"CREATE TABLE t(i INTEGER)", false},
+		{"CREATE VIEW temperature AS SELECT 1", false},
+		{"INSERT INTO t VALUES (1)", false},
+		{"ATTACH 'db.duckdb'", false},
+		{"SETUP", false},
+	}
+	for _, tt := range tests {
+		if got := isSessionStatement(tt.query); got != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.expected, got)
+		}
+	}
+}
+
+func TestConnectInitSqlOnNewConnections(t *testing.T) {
+	initSql := `CREATE TABLE t AS SELECT 1 AS x;
+		INSERT INTO t VALUES (2);
+		CREATE TEMP VIEW v AS SELECT x FROM t;
+		SET VARIABLE answer = 42;`
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{"path": "", "initSql": %q}`, initSql)),
+	}
+	driver := &DuckDBDriver{}
+
+	for _, attempt := range []string{"connect", "reconnect"} {
+		db, err := driver.Connect(context.Background(), settings, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		// Hold the first connection so that the second one is freshly opened.
+		first, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+		second, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", attempt, err)
+		}
+		defer second.Close()
+
+		for i, conn := range []*sql.Conn{first, second} {
+			var count, answer int
+			err := conn.QueryRowContext(context.Background(), "SELECT count(*), getvariable('answer') FROM v").Scan(&count, &answer)
+			if err != nil {
+				t.Fatalf("%s, connection %d: %v", attempt, i+1, err)
+			}
+			// The table is created and filled once per database.
+			if count != 2 || answer != 42 {
+				t.Errorf("%s, connection %d: expected 2 rows and 42, got %d and %d", attempt, i+1, count, answer)
+			}
+		}
+	}
+}
+
+func TestMacroReadFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"parquet", []string{"'s3://bucket/y=*/*.parquet'", "parquet"}, "read_parquet('s3://bucket/y=*/*.parquet')"},
+		{"csv", []string{" /data/*.csv ", " CSV "}, "read_csv_auto('/data/*.csv')"},
+		{"json", []string{"/data/*.json", "json"}, "read_json_auto('/data/*.json')"},
+		{"escaped", []string{"'/data/it''s/*.csv'", "csv"}, "read_csv_auto('/data/it''s/*.csv')"},
+		{"unquoted quote", []string{"/data/it's/*.csv", "csv"}, "read_csv_auto('/data/it''s/*.csv')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := macroReadFiles(macroQuery(), tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	for _, args := range [][]string{nil, {"/data/*.csv"}, {" ", "csv"}, {"/data/*.csv", "csv", "x"}} {
+		if _, err := macroReadFiles(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+	if _, err := macroReadFiles(macroQuery(), []string{"/data/*.xlsx", "excel"}); err == nil || !strings.Contains(err.Error(), `invalid file format "excel"`) {
+		t.Errorf("expected an invalid format error, got %v", err)
+	}
+}
+
+func TestReadFilesQuery(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"a.csv", "b.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(fmt.Sprintf("x\n%d\n", i+1)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ds := newTestDatasource(t, `{"path": ""}`)
+	res := runQuery(t, ds, fmt.Sprintf("SELECT sum(x)::INTEGER AS total FROM $__readFiles('%s', csv)", filepath.Join(dir, "*.csv")))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := res.Frames[0].Fields[0].At(0); got == nil || *got.(*int32) != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+}
+
+func TestMacroInClause(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{"single", "$__inClause(host, 'a')", `host IN ('a')`},
+		{"multiple", "$__inClause(host, 'a','b','c')", `host IN ('a', 'b', 'c')`},
+		{"unquoted", "$__inClause(id, 1, 2)", `id IN ('1', '2')`},
+		{"escaped quotes", "$__inClause(name, 'o''brien','x''; DROP TABLE t; --')", `name IN ('o''brien', 'x''; DROP TABLE t; --')`},
+		{"comma in value", "$__inClause(name, 'a,b','c')", `name IN ('a,b', 'c')`},
+		{"quoted column", `$__inClause(my"col, 'a')`, `"my""col" IN ('a')`},
+		{"empty", "$__inClause(host)", "FALSE"},
+		{"empty value", "$__inClause(host, )", "FALSE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sqlutil.Interpolate(macroQuery().WithSQL(tt.sql), (&DuckDBDriver{}).Macros())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := macroInClause(macroQuery(), nil); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+		t.Errorf("expected ErrorBadArgumentCount without arguments, got %v", err)
+	}
+	for _, args := range [][]string{{"host", "'a"}, {"host", "'a'b"}} {
+		if _, err := macroInClause(macroQuery(), args); err == nil {
+			t.Errorf("expected an error for %q", args)
+		}
+	}
+}
+
+func TestMacroUnnest(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{"column", "SELECT $__unnest(values) AS v FROM t", `SELECT unnest(values) AS v FROM t`},
+		{"spaces", "SELECT $__unnest( values )", `SELECT unnest(values)`},
+		{"quoted column", `SELECT $__unnest(my"col)`, `SELECT unnest("my""col")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sqlutil.Interpolate(macroQuery().WithSQL(tt.sql), (&DuckDBDriver{}).Macros())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	for _, args := range [][]string{nil, {""}, {"a", "b"}} {
+		if _, err := macroUnnest(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+
+	ds := newTestDatasource(t, `{"path": ""}`)
+	res := runQuery(t, ds, "SELECT name, $__unnest(latencies) AS latency FROM (SELECT 'a' AS name, [10, 20, 30] AS latencies)")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if rows := res.Frames[0].Rows(); rows != 3 {
+		t.Errorf("expected 3 rows, got %d", rows)
+	}
+}
+
+func TestMacroQuoteIdent(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{"plain", "SELECT $__quoteIdent(value)", `SELECT "value"`},
+		{"spaces", "SELECT $__quoteIdent( order date )", `SELECT "order date"`},
+		{"reserved word", "SELECT $__quoteIdent(select)", `SELECT "select"`},
+		{"embedded quote", `SELECT $__quoteIdent(a"b)`, `SELECT "a""b"`},
+		{"already quoted", `SELECT $__quoteIdent("a""b")`, `SELECT "a""b"`},
+		{"unbalanced quotes", `SELECT $__quoteIdent("a"")`, `SELECT """a"""""`},
+		{"dots", "SELECT $__quoteIdent(sales.total)", `SELECT "sales.total"`},
+		{"time filter", `WHERE $__timeFilter("event time")`, `WHERE "event time" >= '2024-03-10T11:07:42Z' AND "event time" <= '2024-03-10T12:52:03Z'`},
+		{"time group", `GROUP BY $__timeGroup(a"b, 5m)`, `GROUP BY time_bucket(INTERVAL '5 minutes', "a""b")`},
+		{"unix epoch filter", `WHERE $__unixEpochFilter(from)`, `WHERE "from" >= 1710068862 AND "from" <= 1710075123`},
+		{"unix epoch group", `GROUP BY $__unixEpochGroup("my ts", 1m)`, `GROUP BY ("my ts" // 60) * 60`},
+		{"in clause", `WHERE $__inClause("host name", 'a')`, `WHERE "host name" IN ('a')`},
+		{"unnest", `SELECT $__unnest("values")`, `SELECT unnest("values")`},
+		{"plain column", `WHERE $__timeFilter(ts)`, `WHERE ts >= '2024-03-10T11:07:42Z' AND ts <= '2024-03-10T12:52:03Z'`},
+		{"qualified column", `WHERE $__timeFilter(t.ts)`, `WHERE t.ts >= '2024-03-10T11:07:42Z' AND t.ts <= '2024-03-10T12:52:03Z'`},
+		{"qualified names needing quotes", `WHERE $__unixEpochFilter(my table."my ts")`, `WHERE "my table"."my ts" >= 1710068862 AND "my table"."my ts" <= 1710075123`},
+		{"qualified reserved word", `GROUP BY $__unixEpochGroup(t.from, 1m)`, `GROUP BY (t."from" // 60) * 60`},
+		{"expression", `GROUP BY $__timeGroup(to_timestamp(ts), 1h)`, `GROUP BY time_bucket(INTERVAL '1 hours', to_timestamp(ts))`},
+		{"cast expression", `WHERE $__inClause(host::VARCHAR, 'a')`, `WHERE host::VARCHAR IN ('a')`},
+		{"quoted expression", `SELECT $__unnest(list_value("a", "b"))`, `SELECT unnest(list_value("a", "b"))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sqlutil.Interpolate(macroQuery().WithSQL(tt.sql), (&DuckDBDriver{}).Macros())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	for _, args := range [][]string{nil, {" "}, {"a", "b"}} {
+		if _, err := macroQuoteIdent(macroQuery(), args); !errors.Is(err, sqlutil.ErrorBadArgumentCount) {
+			t.Errorf("expected ErrorBadArgumentCount for %q, got %v", args, err)
+		}
+	}
+
+	ds := newTestDatasource(t, `{"path": ""}`)
+	res := runQuery(t, ds, `SELECT $__quoteIdent(order date) + $__quoteIdent(select) + $__quoteIdent(a"b) AS total
+		FROM (SELECT 1 AS "order date", 2 AS "select", 3 AS "a""b")`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got, ok := res.Frames[0].Fields[0].At(0).(*int32); !ok || got == nil || *got != 6 {
+		t.Errorf("expected 6, got %v", res.Frames[0].Fields[0].At(0))
+	}
+
+	// Qualified columns and expressions bind as they did before quoting.
+	now := time.Now().UTC()
+	timeRange := backend.TimeRange{From: now.Add(-time.Hour), To: now.Add(time.Hour)}
+	model, err := json.Marshal(map[string]any{"format": 1, "rawSql": `SELECT count(*)::INTEGER AS n
+		FROM (SELECT now()::TIMESTAMP AS ts, epoch(now())::BIGINT AS "from") t
+		WHERE $__timeFilter(t.ts) AND $__unixEpochFilter(t.from)
+		GROUP BY $__timeGroup(to_timestamp(epoch(ts)), 1h)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = runDataQuery(t, ds, backend.DataQuery{TimeRange: timeRange, JSON: model})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got, ok := res.Frames[0].Fields[0].At(0).(*int32); !ok || got == nil || *got != 1 {
+		t.Errorf("expected 1 matching row, got %v", res.Frames[0].Fields[0].At(0))
+	}
+}
+
+func TestMacroInClauseQuery(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+	res := runQuery(t, ds, `SELECT count(*)::INTEGER AS n FROM (VALUES ('a'), ('it''s'), ('c')) t(v) WHERE $__inClause(v, 'a','it''s','x')`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := res.Frames[0].Fields[0].At(0); got == nil || *got.(*int32) != 2 {
+		t.Errorf("expected 2 matching rows, got %v", got)
+	}
+}
+
+func TestBootQueriesCollation(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", "")
+
+	tests := []struct {
+		collation string
+		expected  []string
+	}{
+		{"", []string{}},
+		{" de ", []string{"INSTALL 'icu';", "LOAD 'icu';", "SET default_collation='de';"}},
+		{"de_at.noaccent", []string{"INSTALL 'icu';", "LOAD 'icu';", "SET default_collation='de_at.noaccent';"}},
+		{"NOCASE.noaccent", []string{"SET default_collation='NOCASE.noaccent';"}},
+	}
+	for _, tt := range tests {
+		config := &models.PluginSettings{Collation: tt.collation, Secrets: &models.SecretPluginSettings{}}
+		got, err := bootQueries(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: expected %q, got %q", tt.collation, tt.expected, got)
+		}
+	}
+
+	// icu is installed once, with the pinned version, and the collation is set
+	// before the generic settings.
+	config := &models.PluginSettings{
+		Collation:         "sv",
+		Extensions:        []string{"ICU"},
+		ExtensionVersions: map[string]string{"icu": "v1.4.4"},
+		DuckDBSettings:    map[string]string{"threads": "2"},
+		Secrets:           &models.SecretPluginSettings{},
+	}
+	expected := []string{"INSTALL 'ICU' VERSION 'v1.4.4';", "LOAD 'ICU';", "SET default_collation='sv';", "SET threads='2';"}
+	if got, _ := bootQueries(config); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	for collation, message := range map[string]string{
+		"de-DE":               "ICU collations use underscores, e.g. de_DE",
+		"'de'":                "example input: de",
+		"de; DROP TABLE t":    "example input: de",
+		"nocase..noaccent":    "example input: de",
+		"de.":                 "example input: de",
+		"de' ; DROP TABLE t;": "example input: de",
+	} {
+		var configErr *ConfigError
+		_, err := bootQueries(&models.PluginSettings{Collation: collation, Secrets: &models.SecretPluginSettings{}})
+		if !errors.As(err, &configErr) || !strings.Contains(err.Error(), message) {
+			t.Errorf("%q: expected a ConfigError containing %q, got %v", collation, message, err)
+		}
+	}
+}
+
+func TestConnectCollation(t *testing.T) {
+	// The icu extension is built in, the builtin collations don't install it.
+	ds := newTestDatasource(t, `{"path": "", "collation": "nocase"}`)
+	res := runQuery(t, ds, "SELECT s FROM (VALUES ('b'), ('A'), ('a'), ('B')) t(s) ORDER BY s, s::BLOB")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "s", data.FieldTypeNullableString, []any{"A", "a", "B", "b"})
+}
+
+func TestNormalizeMotherDuckPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		message  string
+	}{
+		{"md:my_db", "md:my_db", ""},
+		{" md: foo ", "md:foo", ""},
+		{"md:\tmy-db\n", "md:my-db", ""},
+		{"md:it's_db", "md:it's_db", ""},
+		{"md:_share/sales/6a1f", "md:_share/sales/6a1f", ""},
+		{"md:my_db?attach_mode=single", "md:my_db?attach_mode=single", ""},
+		{"md: my_db ?saas_mode=true&attach_mode=single", "md:my_db?saas_mode=true&attach_mode=single", ""},
+		{"md:", "", "is missing the database name -> example input: md:my_db"},
+		{"md:   ", "", "is missing the database name"},
+		{"md:?attach_mode=single", "", "is missing the database name"},
+		{"md:my db", "", `must not contain ' '`},
+		{`md:"my_db"`, "", `must not contain '"'`},
+		{"md:my_db;DROP", "", `must not contain ';'`},
+		{"md:my_db?attach mode=single", "", "Invalid MotherDuck path option: attach mode=single"},
+		{"md:my_db?token", "", "Invalid MotherDuck path option: token"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeMotherDuckPath(tt.path)
+		if tt.message == "" {
+			if err != nil || got != tt.expected {
+				t.Errorf("%q: expected %q, got %q, %v", tt.path, tt.expected, got, err)
+			}
+			continue
+		}
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%q: expected a ConfigError containing %q, got %v", tt.path, tt.message, err)
+		}
+	}
+
+	// The normalized path is attached, still quoted.
+	t.Setenv("GF_PATHS_DATA", "")
+	queries, err := bootQueries(&models.PluginSettings{Path: "md: foo", Secrets: &models.SecretPluginSettings{MotherDuckToken: "token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := queries[len(queries)-1]; last != "ATTACH IF NOT EXISTS 'md:foo' (TYPE motherduck);" {
+		t.Errorf("expected the normalized path to be attached, got %q", last)
+	}
+	var configErr *ConfigError
+	if _, err := bootQueries(&models.PluginSettings{Path: "md:", Secrets: &models.SecretPluginSettings{MotherDuckToken: "token"}}); !errors.As(err, &configErr) {
+		t.Errorf("expected a ConfigError for an empty database name, got %v", err)
+	}
+	if _, err := attachQuery(models.Attachment{Path: "md:my db"}); !errors.As(err, &configErr) {
+		t.Errorf("expected a ConfigError for an attachment, got %v", err)
+	}
+}
+
+func TestNullColumns(t *testing.T) {
+	// DuckDB types untyped NULL result columns as INTEGER, so they come back as
+	// valid frames holding only NULLs without a converter of their own.
+	ds := newTestDatasource(t, `{"path": ""}`)
+	for rawSQL, rows := range map[string]int{
+		"SELECT NULL AS x":                               1,
+		"SELECT NULL AS x UNION ALL SELECT NULL":         2,
+		"SELECT * FROM (VALUES (NULL), (NULL)) t(x)":     2,
+		"SELECT NULL AS x FROM range(3) WHERE range < 0": 0,
+	} {
+		res := runQuery(t, ds, rawSQL)
+		if res.Error != nil {
+			t.Fatalf("%s: %v", rawSQL, res.Error)
+		}
+		field, _ := res.Frames[0].FieldByName("x")
+		if field == nil || field.Len() != rows {
+			t.Fatalf("%s: expected a field x with %d rows, got %v", rawSQL, rows, res.Frames[0].Fields)
+		}
+		for i := 0; i < rows; i++ {
+			if _, ok := field.ConcreteAt(i); ok {
+				t.Errorf("%s: expected row %d to be NULL, got %v", rawSQL, i, field.At(i))
+			}
+		}
+	}
+}
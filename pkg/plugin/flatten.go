@@ -0,0 +1,288 @@
+package plugin
+
+import (
+	"database/sql/driver"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// maxExpandedArraySize is the size up to which ARRAY columns are expanded.
+// Larger arrays, like embeddings, stay single JSON columns.
+const maxExpandedArraySize = 100
+
+// flatColumn is a column of flattened rows, either a column of the underlying
+// rows, a field of one of its STRUCT columns or an element of one of its
+// ARRAY columns.
+type flatColumn struct {
+	name     string
+	typeName string
+	scanType reflect.Type
+	// source is the index of the underlying column.
+	source int
+	// field is the STRUCT field, empty for columns that are passed through.
+	field     string
+	flattened bool
+	// index is the ARRAY element of expanded columns.
+	index    int
+	expanded bool
+}
+
+// flattenedRows expands top-level STRUCT columns into one column per field,
+// named "column.field", and fixed-size ARRAY columns into one column per
+// element, named "column[i]". The columns are typed like the field or
+// element, so their converters apply. Nested STRUCTs and ARRAYs as well as
+// variable-length LISTs stay single columns.
+type flattenedRows struct {
+	typedRows
+	columns []flatColumn
+	names   []string
+	values  []driver.Value
+}
+
+// flattenRows returns rows with the STRUCT columns of rows flattened when
+// structs is set and the ARRAY columns expanded when arrays is set, or rows
+// itself when there are none.
+func flattenRows(rows typedRows, structs, arrays bool) typedRows {
+	source := rows.Columns()
+	columns := make([]flatColumn, 0, len(source))
+	flattened := false
+	for i, name := range source {
+		typeName := rows.ColumnTypeDatabaseTypeName(i)
+		if element, size, ok := parseArrayType(typeName); arrays && ok && size <= maxExpandedArraySize {
+			flattened = true
+			for index := range size {
+				columns = append(columns, flatColumn{
+					name:     name + "[" + strconv.Itoa(index) + "]",
+					typeName: element,
+					scanType: structFieldScanType(element),
+					source:   i,
+					index:    index,
+					expanded: true,
+				})
+			}
+			continue
+		}
+		fields, ok := parseStructType(typeName)
+		if !structs || !ok || len(fields) == 0 {
+			columns = append(columns, flatColumn{name: name, typeName: typeName, scanType: rows.ColumnTypeScanType(i), source: i})
+			continue
+		}
+		flattened = true
+		for _, field := range fields {
+			columns = append(columns, flatColumn{
+				name:      name + "." + field.name,
+				typeName:  field.typeName,
+				scanType:  structFieldScanType(field.typeName),
+				source:    i,
+				field:     field.name,
+				flattened: true,
+			})
+		}
+	}
+	if !flattened {
+		return rows
+	}
+
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return &flattenedRows{
+		typedRows: rows,
+		columns:   columns,
+		names:     names,
+		values:    make([]driver.Value, len(source)),
+	}
+}
+
+func (r *flattenedRows) Columns() []string {
+	return r.names
+}
+
+func (r *flattenedRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columns[index].typeName
+}
+
+func (r *flattenedRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.columns[index].scanType
+}
+
+func (r *flattenedRows) Next(dest []driver.Value) error {
+	if err := r.typedRows.Next(r.values); err != nil {
+		return err
+	}
+	for i, column := range r.columns {
+		value := r.values[column.source]
+		switch {
+		case column.flattened:
+			// A NULL STRUCT makes all of its fields NULL.
+			fields, _ := value.(map[string]any)
+			value = fields[column.field]
+		case column.expanded:
+			// So does a NULL ARRAY for its elements.
+			elements, _ := value.([]any)
+			value = nil
+			if column.index < len(elements) {
+				value = elements[column.index]
+			}
+		}
+		dest[i] = value
+	}
+	return nil
+}
+
+type structField struct {
+	name     string
+	typeName string
+}
+
+// parseStructType splits a type name like STRUCT("a" INTEGER, "b" VARCHAR[])
+// into its fields. DuckDB always quotes the field names.
+func parseStructType(typeName string) ([]structField, bool) {
+	if !strings.HasPrefix(typeName, "STRUCT(") || !strings.HasSuffix(typeName, ")") {
+		return nil, false
+	}
+	body := typeName[len("STRUCT(") : len(typeName)-1]
+
+	var fields []structField
+	for len(body) > 0 {
+		if body[0] != '"' {
+			return nil, false
+		}
+		// Field names escape quotes by doubling them.
+		var name strings.Builder
+		i := 1
+		for {
+			if i >= len(body) {
+				return nil, false
+			}
+			if body[i] == '"' {
+				if i+1 < len(body) && body[i+1] == '"' {
+					name.WriteByte('"')
+					i += 2
+					continue
+				}
+				i++
+				break
+			}
+			name.WriteByte(body[i])
+			i++
+		}
+
+		// The type ends at the first comma outside of parentheses and quotes.
+		start, depth, quote := i, 0, byte(0)
+	scan:
+		for ; i < len(body); i++ {
+			c := body[i]
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '(' || c == '[':
+				depth++
+			case c == ')' || c == ']':
+				depth--
+			case c == ',' && depth == 0:
+				break scan
+			}
+		}
+		fieldType := strings.TrimSpace(body[start:i])
+		if fieldType == "" {
+			return nil, false
+		}
+		fields = append(fields, structField{name: name.String(), typeName: canonicalTypeName(fieldType)})
+		if i < len(body) {
+			i++
+		}
+		body = strings.TrimLeft(body[i:], " ")
+	}
+	return fields, true
+}
+
+// parseArrayType splits a fixed-size ARRAY type name like DOUBLE[3] into its
+// element type and size. LISTs, like DOUBLE[], have no size.
+func parseArrayType(typeName string) (string, int, bool) {
+	if !strings.HasSuffix(typeName, "]") {
+		return "", 0, false
+	}
+	open := strings.LastIndexByte(typeName, '[')
+	if open <= 0 {
+		return "", 0, false
+	}
+	size, err := strconv.Atoi(typeName[open+1 : len(typeName)-1])
+	if err != nil || size <= 0 {
+		return "", 0, false
+	}
+	return typeName[:open], size, true
+}
+
+// canonicalTypeName maps the names DuckDB uses in nested type names to the
+// names duckdb-go reports for columns, which the converters match on.
+func canonicalTypeName(typeName string) string {
+	switch typeName {
+	case "TIMESTAMP WITH TIME ZONE":
+		return "TIMESTAMPTZ"
+	case "TIME WITH TIME ZONE":
+		return "TIMETZ"
+	}
+	return typeName
+}
+
+var structFieldScanTypes = map[string]reflect.Type{
+	"BOOLEAN":      reflect.TypeFor[bool](),
+	"TINYINT":      reflect.TypeFor[int8](),
+	"SMALLINT":     reflect.TypeFor[int16](),
+	"INTEGER":      reflect.TypeFor[int32](),
+	"BIGINT":       reflect.TypeFor[int64](),
+	"UTINYINT":     reflect.TypeFor[uint8](),
+	"USMALLINT":    reflect.TypeFor[uint16](),
+	"UINTEGER":     reflect.TypeFor[uint32](),
+	"UBIGINT":      reflect.TypeFor[uint64](),
+	"FLOAT":        reflect.TypeFor[float32](),
+	"DOUBLE":       reflect.TypeFor[float64](),
+	"VARCHAR":      reflect.TypeFor[string](),
+	"BLOB":         reflect.TypeFor[[]byte](),
+	"UUID":         reflect.TypeFor[[]byte](),
+	"INTERVAL":     reflect.TypeFor[duckdb.Interval](),
+	"HUGEINT":      reflect.TypeFor[*big.Int](),
+	"UHUGEINT":     reflect.TypeFor[*big.Int](),
+	"DATE":         reflect.TypeFor[time.Time](),
+	"TIME":         reflect.TypeFor[time.Time](),
+	"TIMETZ":       reflect.TypeFor[time.Time](),
+	"TIMESTAMP":    reflect.TypeFor[time.Time](),
+	"TIMESTAMPTZ":  reflect.TypeFor[time.Time](),
+	"TIMESTAMP_S":  reflect.TypeFor[time.Time](),
+	"TIMESTAMP_MS": reflect.TypeFor[time.Time](),
+	"TIMESTAMP_NS": reflect.TypeFor[time.Time](),
+}
+
+// structFieldScanType returns the type duckdb-go scans a STRUCT field or an
+// ARRAY element of the given type into, like it reports for top-level columns.
+func structFieldScanType(typeName string) reflect.Type {
+	if t, ok := structFieldScanTypes[typeName]; ok {
+		return t
+	}
+	switch {
+	case strings.HasPrefix(typeName, "DECIMAL("):
+		return reflect.TypeFor[duckdb.Decimal]()
+	case strings.HasPrefix(typeName, "ENUM("):
+		return reflect.TypeFor[string]()
+	case strings.HasPrefix(typeName, "STRUCT("):
+		return reflect.TypeFor[map[string]any]()
+	case strings.HasPrefix(typeName, "MAP("):
+		return reflect.TypeFor[duckdb.Map]()
+	case strings.HasPrefix(typeName, "UNION("):
+		return reflect.TypeFor[duckdb.Union]()
+	case strings.HasSuffix(typeName, "]"):
+		return reflect.TypeFor[[]any]()
+	}
+	return reflect.TypeFor[any]()
+}
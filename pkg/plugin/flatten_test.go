@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestParseStructType(t *testing.T) {
+	tests := []struct {
+		typeName string
+		expected []structField
+		ok       bool
+	}{
+		{`STRUCT("a" INTEGER, "b c" VARCHAR)`, []structField{{"a", "INTEGER"}, {"b c", "VARCHAR"}}, true},
+		{`STRUCT("q""x" BOOLEAN)`, []structField{{`q"x`, "BOOLEAN"}}, true},
+		{`STRUCT("d" DECIMAL(5,2), "n" STRUCT("e" INTEGER, "f" VARCHAR[]), "l" INTEGER[])`, []structField{
+			{"d", "DECIMAL(5,2)"}, {"n", `STRUCT("e" INTEGER, "f" VARCHAR[])`}, {"l", "INTEGER[]"},
+		}, true},
+		{`STRUCT("e" ENUM('a,b', 'c'), "ts" TIMESTAMP WITH TIME ZONE)`, []structField{{"e", "ENUM('a,b', 'c')"}, {"ts", "TIMESTAMPTZ"}}, true},
+		{`STRUCT(a INTEGER)`, nil, false},
+		{`MAP(VARCHAR, INTEGER)`, nil, false},
+		{`INTEGER`, nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseStructType(tt.typeName)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.typeName, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
+
+const flattenQuery = `SELECT 1 AS id, {'a': 1, 'b': 'x', 'ts': TIMESTAMP '2024-01-02 03:04:05', 'n': {'c': 2}} AS s
+UNION ALL SELECT 2, NULL ORDER BY id`
+
+func TestFlattenStructs(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "flattenStructs": true}`)
+
+	res := runQuery(t, ds, flattenQuery)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	names := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		names[i] = field.Name
+	}
+	if expected := []string{"id", "s.a", "s.b", "s.ts", "s.n"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected fields %v, got %v", expected, names)
+	}
+
+	assertField(t, frame, "s.a", data.FieldTypeNullableInt32, []any{int32(1), nil})
+	assertField(t, frame, "s.b", data.FieldTypeNullableString, []any{"x", nil})
+	assertField(t, frame, "s.ts", data.FieldTypeNullableTime, []any{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), nil})
+	// Nested STRUCTs stay JSON.
+	assertField(t, frame, "s.n", data.FieldTypeNullableString, []any{`{"c":2}`, nil})
+}
+
+func TestFlattenStructsDisabled(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	res := runQuery(t, ds, flattenQuery)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if len(frame.Fields) != 2 {
+		t.Fatalf("expected 2 fields, got %d", len(frame.Fields))
+	}
+	assertField(t, frame, "s", data.FieldTypeNullableString, []any{`{"a":1,"b":"x","n":{"c":2},"ts":"2024-01-02T03:04:05Z"}`, nil})
+}
+
+func TestParseArrayType(t *testing.T) {
+	tests := []struct {
+		typeName string
+		element  string
+		size     int
+		ok       bool
+	}{
+		{"DOUBLE[3]", "DOUBLE", 3, true},
+		{"DECIMAL(5,2)[2]", "DECIMAL(5,2)", 2, true},
+		{"INTEGER[2][4]", "INTEGER[2]", 4, true},
+		{`STRUCT("a" INTEGER)[2]`, `STRUCT("a" INTEGER)`, 2, true},
+		{"DOUBLE[]", "", 0, false},
+		{"INTEGER[3][]", "", 0, false},
+		{"DOUBLE", "", 0, false},
+		{"[3]", "", 0, false},
+	}
+	for _, tt := range tests {
+		element, size, ok := parseArrayType(tt.typeName)
+		if element != tt.element || size != tt.size || ok != tt.ok {
+			t.Errorf("%s: expected %q %d %v, got %q %d %v", tt.typeName, tt.element, tt.size, tt.ok, element, size, ok)
+		}
+	}
+}
+
+const arrayQuery = `SELECT 1 AS id, [1.5, 2, 3]::DOUBLE[3] AS a, [1, 2]::INTEGER[] AS l, [10, NULL]::BIGINT[2] AS b
+UNION ALL SELECT 2, NULL, NULL, NULL ORDER BY id`
+
+func TestExpandArrays(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "expandArrays": true}`)
+
+	res := runQuery(t, ds, arrayQuery)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	names := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		names[i] = field.Name
+	}
+	if expected := []string{"id", "a[0]", "a[1]", "a[2]", "l", "b[0]", "b[1]"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected fields %v, got %v", expected, names)
+	}
+
+	assertField(t, frame, "a[0]", data.FieldTypeNullableFloat64, []any{1.5, nil})
+	assertField(t, frame, "a[1]", data.FieldTypeNullableFloat64, []any{2.0, nil})
+	assertField(t, frame, "a[2]", data.FieldTypeNullableFloat64, []any{3.0, nil})
+	assertField(t, frame, "b[0]", data.FieldTypeNullableInt64, []any{int64(10), nil})
+	assertField(t, frame, "b[1]", data.FieldTypeNullableInt64, []any{nil, nil})
+	// Variable-length LISTs stay JSON.
+	assertField(t, frame, "l", data.FieldTypeNullableString, []any{"[1,2]", nil})
+}
+
+func TestExpandArraysDisabled(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "flattenStructs": true}`)
+
+	res := runQuery(t, ds, arrayQuery)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if len(frame.Fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d", len(frame.Fields))
+	}
+	assertField(t, frame, "a", data.FieldTypeNullableString, []any{"[1.5,2,3]", nil})
+}
+
+func TestExpandArraysSizeLimit(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "expandArrays": true}`)
+
+	res := runQuery(t, ds, "SELECT range(101)::INTEGER[101] AS embedding")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if fields := res.Frames[0].Fields; len(fields) != 1 || fields[0].Type() != data.FieldTypeNullableString {
+		t.Errorf("expected a single JSON column for a large array, got %d fields", len(fields))
+	}
+}
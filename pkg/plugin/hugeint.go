@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// formatBigInt formats a HUGEINT or UHUGEINT value like big.Int.String, but
+// without the allocations of big.Int's general purpose conversion: values that
+// fit 64 bits use strconv and the others are formatted from their two 64-bit
+// words. Values beyond 128 bits, which DuckDB does not return, fall back to
+// big.Int.String.
+func formatBigInt(v *big.Int) string {
+	if v.IsInt64() {
+		return strconv.FormatInt(v.Int64(), 10)
+	}
+	hi, lo, ok := uint128Words(v)
+	if !ok {
+		return v.String()
+	}
+	return formatUint128(v.Sign() < 0, hi, lo)
+}
+
+// uint128Words returns the absolute value of v as two 64-bit words.
+func uint128Words(v *big.Int) (hi, lo uint64, ok bool) {
+	if v.BitLen() > 128 {
+		return 0, 0, false
+	}
+	for i, w := range v.Bits() {
+		shift := uint(i * bits.UintSize)
+		if shift < 64 {
+			lo |= uint64(w) << shift
+		} else {
+			hi |= uint64(w) << (shift - 64)
+		}
+	}
+	return hi, lo, true
+}
+
+// chunk is the largest power of ten below 2^64, the remainders of dividing by
+// it have up to chunkDigits digits.
+const (
+	chunk       = 10_000_000_000_000_000_000
+	chunkDigits = 19
+)
+
+// formatUint128 formats the 128-bit magnitude hi:lo in decimal, with a minus
+// sign when neg is set. It divides by 10^19 until the value fits 64 bits, each
+// division yielding 19 digits.
+func formatUint128(neg bool, hi, lo uint64) string {
+	// 2^128 has 39 digits, plus the sign.
+	var buf [40]byte
+	i := len(buf)
+	for hi != 0 {
+		var rem uint64
+		hi, rem = hi/chunk, hi%chunk
+		lo, rem = bits.Div64(rem, lo, chunk)
+		for j := 0; j < chunkDigits; j++ {
+			i--
+			buf[i] = byte('0' + rem%10)
+			rem /= 10
+		}
+	}
+	for {
+		i--
+		buf[i] = byte('0' + lo%10)
+		lo /= 10
+		if lo == 0 {
+			break
+		}
+	}
+	if neg {
+		i--
+		buf[i] = '-'
+	}
+	return string(buf[i:])
+}
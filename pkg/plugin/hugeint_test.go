@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"math/big"
+	"testing"
+)
+
+func bigIntFromString(t testing.TB, s string) *big.Int {
+	t.Helper()
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("invalid integer %q", s)
+	}
+	return v
+}
+
+var hugeIntValues = []string{
+	"0",
+	"-1",
+	"1",
+	"9223372036854775807",  // max int64
+	"-9223372036854775808", // min int64
+	"9223372036854775808",  // max int64 + 1
+	"-9223372036854775809", // min int64 - 1
+	"18446744073709551615", // max uint64
+	"18446744073709551616", // 2^64
+	"10000000000000000000", // 10^19
+	"100000000000000000000000000000000000005",  // zero padded chunks
+	"-100000000000000000000000000000000000005", // zero padded chunks
+	"170141183460469231731687303715884105727",  // max HUGEINT
+	"-170141183460469231731687303715884105728", // min HUGEINT
+	"340282366920938463463374607431768211455",  // max UHUGEINT
+}
+
+func TestFormatBigInt(t *testing.T) {
+	for _, s := range hugeIntValues {
+		if got := formatBigInt(bigIntFromString(t, s)); got != s {
+			t.Errorf("expected %s, got %s", s, got)
+		}
+	}
+
+	// Wider values are not returned by DuckDB but still formatted.
+	wide := "-3402823669209384634633746074317682114550"
+	if got := formatBigInt(bigIntFromString(t, wide)); got != wide {
+		t.Errorf("expected %s, got %s", wide, got)
+	}
+}
+
+func TestHugeIntBoundaries(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+	res := runQuery(t, ds, `SELECT
+		170141183460469231731687303715884105727::HUGEINT AS max,
+		'-170141183460469231731687303715884105728'::HUGEINT AS min,
+		(-1)::HUGEINT AS minus_one,
+		0::HUGEINT AS zero,
+		340282366920938463463374607431768211455::UHUGEINT AS umax`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	expected := []string{
+		"170141183460469231731687303715884105727",
+		"-170141183460469231731687303715884105728",
+		"-1",
+		"0",
+		"340282366920938463463374607431768211455",
+	}
+	for i, field := range res.Frames[0].Fields {
+		if got := *field.At(0).(*string); got != expected[i] {
+			t.Errorf("expected %s for %s, got %s", expected[i], field.Name, got)
+		}
+	}
+}
+
+func BenchmarkFormatBigInt(b *testing.B) {
+	values := make([]*big.Int, len(hugeIntValues))
+	for i, s := range hugeIntValues {
+		values[i] = bigIntFromString(b, s)
+	}
+
+	b.Run("big.Int.String", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = values[i%len(values)].String()
+		}
+	})
+	b.Run("formatBigInt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = formatBigInt(values[i%len(values)])
+		}
+	})
+}
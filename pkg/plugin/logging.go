@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+// withQueryID returns a context whose logger tags every message with a new
+// query ID, so the messages logged for one query can be correlated.
+func withQueryID(ctx context.Context) (context.Context, string) {
+	b := make([]byte, 8)
+	// rand.Read never returns an error.
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	return log.WithContextualAttributes(ctx, []any{"queryId", id}), id
+}
+
+// queryLogger returns the logger for ctx, with the query ID and the request
+// attributes the SDK adds.
+func queryLogger(ctx context.Context) log.Logger {
+	return backend.Logger.FromContext(ctx)
+}
+
+// redactSecrets removes the credentials of the settings from msg, for errors
+// that may quote the statements they were set with.
+func redactSecrets(msg string, config *models.PluginSettings) string {
+	if config == nil || config.Secrets == nil {
+		return msg
+	}
+	for _, secret := range []string{config.Secrets.MotherDuckToken, config.Secrets.CloudSecret} {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, "<redacted>")
+		}
+	}
+	return msg
+}
@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+type logEntry struct {
+	level string
+	msg   string
+	args  []any
+}
+
+// field returns the value logged for key.
+func (e logEntry) field(key string) (any, bool) {
+	for i := 0; i+1 < len(e.args); i += 2 {
+		if e.args[i] == key {
+			return e.args[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// recordingLogger records the messages logged through it and its sub-loggers.
+type recordingLogger struct {
+	mu      *sync.Mutex
+	entries *[]logEntry
+	with    []any
+}
+
+// recordLogs replaces backend.Logger for the duration of the test.
+func recordLogs(t *testing.T) *recordingLogger {
+	t.Helper()
+	logger := &recordingLogger{mu: &sync.Mutex{}, entries: &[]logEntry{}}
+	previous := backend.Logger
+	backend.Logger = logger
+	t.Cleanup(func() { backend.Logger = previous })
+	return logger
+}
+
+func (l *recordingLogger) log(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.entries = append(*l.entries, logEntry{level: level, msg: msg, args: append(append([]any{}, l.with...), args...)})
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.log("debug", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.log("info", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.log("warn", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.log("error", msg, args) }
+func (l *recordingLogger) Level() log.Level              { return log.Debug }
+
+func (l *recordingLogger) With(args ...any) log.Logger {
+	return &recordingLogger{mu: l.mu, entries: l.entries, with: append(append([]any{}, l.with...), args...)}
+}
+
+func (l *recordingLogger) FromContext(ctx context.Context) log.Logger {
+	return l.With(log.ContextualAttributesFromContext(ctx)...)
+}
+
+func (l *recordingLogger) messages() []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]logEntry{}, *l.entries...)
+}
+
+func TestNullBigIntScanLogsValue(t *testing.T) {
+	logs := recordLogs(t)
+
+	var v NullBigInt
+	if err := v.Scan(3.5); err == nil {
+		t.Fatal("expected an error for a float")
+	}
+	entries := logs.messages()
+	if len(entries) != 1 {
+		t.Fatalf("expected one message, got %+v", entries)
+	}
+	entry := entries[0]
+	if strings.Contains(entry.msg, "%") {
+		t.Errorf("expected a message without format verbs, got %q", entry.msg)
+	}
+	if value, ok := entry.field("value"); !ok || value != 3.5 {
+		t.Errorf("expected the value as a field, got %+v", entry.args)
+	}
+	if typ, ok := entry.field("type"); !ok || typ != "float64" {
+		t.Errorf("expected the type as a field, got %+v", entry.args)
+	}
+	if len(entry.args)%2 != 0 {
+		t.Errorf("expected key/value pairs, got %+v", entry.args)
+	}
+}
+
+func TestQueryLogsShareQueryID(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+	logs := recordLogs(t)
+
+	runQuery(t, ds, "SELECT 1")
+	runQuery(t, ds, "SELECT * FROM missing_table")
+
+	ids := map[string]bool{}
+	byID := map[string][]string{}
+	for _, entry := range logs.messages() {
+		id, ok := entry.field("queryId")
+		if !ok {
+			continue
+		}
+		ids[id.(string)] = true
+		byID[id.(string)] = append(byID[id.(string)], entry.msg)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected a query ID per query, got %v", byID)
+	}
+	for id, msgs := range byID {
+		joined := strings.Join(msgs, ", ")
+		if !strings.Contains(joined, "Query started") || !strings.Contains(joined, "Running statement") {
+			t.Errorf("expected the messages of query %s to be correlated, got %s", id, joined)
+		}
+		if !strings.Contains(joined, "Query finished") && !strings.Contains(joined, "Query failed") {
+			t.Errorf("expected query %s to log its outcome, got %s", id, joined)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	config := &models.PluginSettings{Secrets: &models.SecretPluginSettings{MotherDuckToken: "md-token", CloudSecret: "cloud-secret"}}
+	got := redactSecrets("token md-token and secret cloud-secret", config)
+	if got != "token <redacted> and secret <redacted>" {
+		t.Errorf("expected the secrets to be redacted, got %q", got)
+	}
+	if got := redactSecrets("md-token", &models.PluginSettings{}); got != "md-token" {
+		t.Errorf("expected the message to be kept without secrets, got %q", got)
+	}
+
+	// The token of a failing SET is neither in the error nor in the logs.
+	logs := recordLogs(t)
+	err := runInitSql(context.Background(), &failingExecer{failures: map[string][]error{
+		"SET s3_secret_access_key='cloud-secret'": {fmt.Errorf("invalid value cloud-secret")},
+	}}, &models.PluginSettings{
+		InitSql:                "SET s3_secret_access_key='cloud-secret'",
+		InitSqlContinueOnError: true,
+		Secrets:                config.Secrets,
+	}, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range logs.messages() {
+		if strings.Contains(fmt.Sprint(entry.args...), "cloud-secret") {
+			t.Errorf("expected the secret to be redacted from the logs, got %+v", entry)
+		}
+	}
+	if len(logs.messages()) == 0 {
+		t.Error("expected the failing statement to be logged")
+	}
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered with the default registry, which the plugin SDK
+// exposes on the plugin's metrics endpoint.
+var (
+	queryDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Subsystem: "duckdb",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of DuckDB queries, including the wait for a query slot.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"status"})
+	errorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "plugins",
+		Subsystem: "duckdb",
+		Name:      "errors_total",
+		Help:      "Failed queries and connections, by whether the configuration is invalid.",
+	}, []string{"endpoint", "type"})
+	openConnectionsMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "plugins",
+		Subsystem: "duckdb",
+		Name:      "open_connections",
+		Help:      "Number of open DuckDB connections.",
+	})
+)
+
+const (
+	endpointQuery   = "query"
+	endpointConnect = "connect"
+)
+
+// errorType labels err as "config" for a *ConfigError and "runtime" otherwise.
+func errorType(err error) string {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return "config"
+	}
+	return "runtime"
+}
+
+func collectError(endpoint string, err error) {
+	errorsMetric.WithLabelValues(endpoint, errorType(err)).Inc()
+}
+
+// collectQuery records the duration and the outcome of a query.
+func collectQuery(start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+		collectError(endpointQuery, err)
+	}
+	queryDurationMetric.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
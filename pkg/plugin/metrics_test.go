@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func queryCount(t *testing.T, status string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := queryDurationMetric.WithLabelValues(status).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func errorCount(endpoint, errType string) float64 {
+	return testutil.ToFloat64(errorsMetric.WithLabelValues(endpoint, errType))
+}
+
+func TestQueryMetrics(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	ok, failed := queryCount(t, "ok"), queryCount(t, "error")
+	runtimeErrors := errorCount(endpointQuery, "runtime")
+
+	if res := runQuery(t, ds, "SELECT 1"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := queryCount(t, "ok"); got != ok+1 {
+		t.Errorf("expected %d successful queries, got %d", ok+1, got)
+	}
+
+	if res := runQuery(t, ds, "SELECT * FROM missing_table"); res.Error == nil {
+		t.Fatal("expected the query to fail")
+	}
+	if got := queryCount(t, "error"); got != failed+1 {
+		t.Errorf("expected %d failed queries, got %d", failed+1, got)
+	}
+	if got := errorCount(endpointQuery, "runtime"); got != runtimeErrors+1 {
+		t.Errorf("expected %v runtime errors, got %v", runtimeErrors+1, got)
+	}
+	if open := testutil.ToFloat64(openConnectionsMetric); open < 1 {
+		t.Errorf("expected an open connection, got %v", open)
+	}
+}
+
+func TestConfigErrorMetrics(t *testing.T) {
+	connectErrors := errorCount(endpointConnect, "config")
+	queryErrors := errorCount(endpointQuery, "config")
+
+	ds := newTestDatasource(t, `{"path": "", "memoryLimit": "lots"}`)
+	if res := runQuery(t, ds, "SELECT 1"); res.Error == nil {
+		t.Fatal("expected the query to fail")
+	}
+
+	if got := errorCount(endpointConnect, "config"); got != connectErrors+1 {
+		t.Errorf("expected %v connect config errors, got %v", connectErrors+1, got)
+	}
+	if got := errorCount(endpointQuery, "config"); got != queryErrors+1 {
+		t.Errorf("expected %v query config errors, got %v", queryErrors+1, got)
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	if got := errorType(fmt.Errorf("connect: %w", &ConfigError{"bad"})); got != "config" {
+		t.Errorf("expected config, got %s", got)
+	}
+	if got := errorType(errors.New("IO Error")); got != "runtime" {
+		t.Errorf("expected runtime, got %s", got)
+	}
+}
@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+type queryParamsKey struct{}
+
+// withQueryParams returns a context whose query binds params to its
+// positional parameters (? or $1).
+func withQueryParams(ctx context.Context, params []any) context.Context {
+	return context.WithValue(ctx, queryParamsKey{}, params)
+}
+
+// SetQueryArgs passes the parameters of the query to sqlds, which runs the
+// query as a prepared statement with them.
+func (d *DuckDBDriver) SetQueryArgs(ctx context.Context, headers http.Header) []interface{} {
+	params, _ := ctx.Value(queryParamsKey{}).([]any)
+	return params
+}
+
+// queryParams reads the params array of a query model. JSON numbers become
+// int64 when they are integers and float64 otherwise, strings, booleans and
+// null map to string, bool and NULL. Models that fail to parse have no
+// parameters and are left for sqlds to report.
+func queryParams(query backend.DataQuery) ([]any, error) {
+	var model struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		return nil, nil
+	}
+	params := make([]any, len(model.Params))
+	for i, raw := range model.Params {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case nil, string, bool:
+			params[i] = v
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				params[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				params[i] = f
+			} else {
+				return nil, fmt.Errorf("invalid query parameter %d: %w", i+1, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid query parameter %d: expected a number, string, boolean or null, got %s", i+1, raw)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func paramsQuery(t *testing.T, rawSQL string, params ...any) backend.DataQuery {
+	t.Helper()
+	model, err := json.Marshal(map[string]any{"rawSql": rawSQL, "format": 1, "params": params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend.DataQuery{JSON: model}
+}
+
+func TestQueryParams(t *testing.T) {
+	params, err := queryParams(backend.DataQuery{JSON: json.RawMessage(`{"params": [42, 1.5, "it's", true, null, 9007199254740993]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []any{int64(42), 1.5, "it's", true, nil, int64(9007199254740993)}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %#v, got %#v", expected, params)
+	}
+
+	for _, model := range []string{`{"params": [[1]]}`, `{"params": [{"a": 1}]}`} {
+		if _, err := queryParams(backend.DataQuery{JSON: json.RawMessage(model)}); err == nil {
+			t.Errorf("expected an error for %s", model)
+		}
+	}
+}
+
+func TestPreparedStatementParams(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	res := runDataQuery(t, ds, paramsQuery(t, "SELECT ?::INTEGER AS n, ? AS s, ?::VARCHAR AS missing", 42, "o'brien'; DROP TABLE t; --", nil))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	fields := res.Frames[0].Fields
+	if n := *fields[0].At(0).(*int32); n != 42 {
+		t.Errorf("expected 42, got %d", n)
+	}
+	if s := *fields[1].At(0).(*string); s != "o'brien'; DROP TABLE t; --" {
+		t.Errorf("expected the string parameter as is, got %s", s)
+	}
+	if missing := fields[2].At(0).(*string); missing != nil {
+		t.Errorf("expected NULL, got %s", *missing)
+	}
+
+	// Positional parameters can be referenced by number.
+	res = runDataQuery(t, ds, paramsQuery(t, "SELECT $2 || $1 AS s", "b", "a"))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if s := *res.Frames[0].Fields[0].At(0).(*string); s != "ab" {
+		t.Errorf("expected ab, got %s", s)
+	}
+
+	res = runDataQuery(t, ds, paramsQuery(t, "SELECT ? AS s", []int{1}))
+	if res.Error == nil || res.Status != backend.StatusBadRequest {
+		t.Errorf("expected a bad request error for an array parameter, got %v %v", res.Status, res.Error)
+	}
+}
+
+func TestPreparedStatementParamsCache(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "cacheTtlSeconds": 3600}`)
+
+	for _, value := range []string{"a", "b"} {
+		res := runDataQuery(t, ds, paramsQuery(t, "SELECT ? AS s", value))
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		if s := *res.Frames[0].Fields[0].At(0).(*string); s != value {
+			t.Errorf("expected %s, got %s", value, s)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryPivotBy returns the label column named by the pivotBy field of the
+// query model, or an empty string when the query is not pivoted.
+func queryPivotBy(query backend.DataQuery) string {
+	var model struct {
+		PivotBy string `json:"pivotBy"`
+	}
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		// Leave reporting the invalid model to sqlds.
+		return ""
+	}
+	return strings.TrimSpace(model.PivotBy)
+}
+
+// pivotResponse turns the long results of a pivoted query into wide time
+// series frames. Failed responses are returned unchanged.
+func pivotResponse(res backend.DataResponse, label string) backend.DataResponse {
+	if res.Error != nil {
+		return res
+	}
+	frames := make(data.Frames, 0, len(res.Frames))
+	for _, frame := range res.Frames {
+		wide, err := pivotFrame(frame, label)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		frames = append(frames, wide)
+	}
+	res.Frames = frames
+	return res
+}
+
+// pivotFrame reshapes a long frame with a time column, the label column and
+// value columns into a wide frame with one field per distinct label and value
+// column, aligned on the sorted distinct times. Fields are named by the label
+// value, or by the label value and the value column when there are several
+// value columns. Times without a row for a label are NULL. The value fields
+// keep the types the converters gave them.
+func pivotFrame(frame *data.Frame, label string) (*data.Frame, error) {
+	labelField, _ := frame.FieldByName(label)
+	if labelField == nil {
+		return nil, fmt.Errorf("pivot column %q is not in the result", label)
+	}
+	var timeField *data.Field
+	values := []*data.Field{}
+	for _, field := range frame.Fields {
+		switch {
+		case field == labelField:
+		case timeField == nil && field.Type().Time():
+			timeField = field
+		default:
+			values = append(values, field)
+		}
+	}
+	if timeField == nil {
+		return nil, fmt.Errorf("pivoted queries must return a time column")
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("pivoted queries must return a value column besides the time and %q columns", label)
+	}
+
+	rows := timeField.Len()
+	timeIndex := map[time.Time]int{}
+	times := []time.Time{}
+	labelIndex := map[string]bool{}
+	labels := []string{}
+	rowTimes := make([]time.Time, rows)
+	rowLabels := make([]string, rows)
+	for i := 0; i < rows; i++ {
+		v, ok := timeField.ConcreteAt(i)
+		if !ok {
+			return nil, fmt.Errorf("time in row %d is NULL", i+1)
+		}
+		t := v.(time.Time)
+		if _, ok := timeIndex[t]; !ok {
+			timeIndex[t] = len(times)
+			times = append(times, t)
+		}
+		name := "NULL"
+		if v, ok := labelField.ConcreteAt(i); ok {
+			name = fmt.Sprint(v)
+		}
+		if !labelIndex[name] {
+			labelIndex[name] = true
+			labels = append(labels, name)
+		}
+		rowTimes[i], rowLabels[i] = t, name
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i, t := range times {
+		timeIndex[t] = i
+	}
+	sort.Strings(labels)
+
+	wide := data.NewFrame(frame.Name, data.NewField(timeField.Name, nil, times))
+	fields := map[string][]*data.Field{}
+	for _, name := range labels {
+		for _, value := range values {
+			field := data.NewFieldFromFieldType(value.Type().NullableType(), len(times))
+			field.Name = name
+			if len(values) > 1 {
+				field.Name = name + " " + value.Name
+			}
+			field.Config = value.Config
+			fields[name] = append(fields[name], field)
+			wide.Fields = append(wide.Fields, field)
+		}
+	}
+	seen := map[string]bool{}
+	for i := 0; i < rows; i++ {
+		at := timeIndex[rowTimes[i]]
+		cell := fmt.Sprintf("%s\x00%d", rowLabels[i], at)
+		if seen[cell] {
+			return nil, fmt.Errorf("pivot column %q has more than one row for %s at %s", label, rowLabels[i], rowTimes[i].Format(time.RFC3339Nano))
+		}
+		seen[cell] = true
+		for j, value := range values {
+			if v, ok := value.ConcreteAt(i); ok {
+				fields[rowLabels[i]][j].SetConcrete(at, v)
+			}
+		}
+	}
+
+	meta := data.FrameMeta{}
+	if frame.Meta != nil {
+		meta = *frame.Meta
+	}
+	meta.Type = data.FrameTypeTimeSeriesWide
+	wide.Meta = &meta
+	return wide, nil
+}
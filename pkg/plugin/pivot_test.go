@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func runPivotQuery(t *testing.T, rawSQL, pivotBy string) backend.DataResponse {
+	t.Helper()
+	ds := newTestDatasource(t, `{"path": ""}`)
+	// Time series format, which the pivot replaces.
+	model, err := json.Marshal(map[string]any{"rawSql": rawSQL, "format": 0, "pivotBy": pivotBy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runDataQuery(t, ds, backend.DataQuery{JSON: model})
+}
+
+func fieldNames(frame *data.Frame) []string {
+	names := []string{}
+	for _, field := range frame.Fields {
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+func TestPivotQuery(t *testing.T) {
+	res := runPivotQuery(t, `SELECT * FROM (VALUES
+		(TIMESTAMP '2024-03-10 10:01:00', 'web', 1.5),
+		(TIMESTAMP '2024-03-10 10:00:00', 'web', 0.5),
+		(TIMESTAMP '2024-03-10 10:00:00', 'db', 2.0),
+		(TIMESTAMP '2024-03-10 10:02:00', 'db', NULL)
+	) t(time, host, cpu)`, "host")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if names := fieldNames(frame); !reflect.DeepEqual(names, []string{"time", "db", "web"}) {
+		t.Fatalf("expected a field per host, got %v", names)
+	}
+	if frame.Meta == nil || frame.Meta.Type != data.FrameTypeTimeSeriesWide {
+		t.Errorf("expected a wide time series frame, got %+v", frame.Meta)
+	}
+	assertField(t, frame, "time", data.FieldTypeTime, []any{
+		time.Date(2024, 3, 10, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 10, 10, 1, 0, 0, time.UTC),
+		time.Date(2024, 3, 10, 10, 2, 0, 0, time.UTC),
+	})
+	// Sparse labels are NULL at the times they have no row for.
+	assertField(t, frame, "db", data.FieldTypeNullableFloat64, []any{2.0, nil, nil})
+	assertField(t, frame, "web", data.FieldTypeNullableFloat64, []any{0.5, 1.5, nil})
+}
+
+func TestPivotQueryValueTypes(t *testing.T) {
+	// Each value column keeps the type of its converter.
+	res := runPivotQuery(t, `SELECT * FROM (VALUES
+		(TIMESTAMPTZ '2024-03-10 10:00:00+00', 1::INTEGER, 'a', 10::HUGEINT),
+		(TIMESTAMPTZ '2024-03-10 10:00:00+00', 2::INTEGER, 'b', NULL)
+	) t(ts, shard, state, total)`, "shard")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	if names := fieldNames(frame); !reflect.DeepEqual(names, []string{"ts", "1 state", "1 total", "2 state", "2 total"}) {
+		t.Fatalf("expected a field per shard and value column, got %v", names)
+	}
+	assertField(t, frame, "1 state", data.FieldTypeNullableString, []any{"a"})
+	assertField(t, frame, "1 total", data.FieldTypeNullableString, []any{"10"})
+	assertField(t, frame, "2 total", data.FieldTypeNullableString, []any{nil})
+}
+
+func TestPivotQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawSQL  string
+		pivotBy string
+		message string
+	}{
+		{"missing label column", "SELECT now() AS time, 1 AS v", "host", `pivot column "host" is not in the result`},
+		{"missing time column", "SELECT 'web' AS host, 1 AS v", "host", "must return a time column"},
+		{"missing value column", "SELECT now() AS time, 'web' AS host", "host", "must return a value column"},
+		{"null time", "SELECT NULL::TIMESTAMP AS time, 'web' AS host, 1 AS v", "host", "time in row 1 is NULL"},
+		{"duplicate rows", "SELECT TIMESTAMP '2024-01-01' AS time, 'web' AS host, range AS v FROM range(2)", "host", "more than one row for web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runPivotQuery(t, tt.rawSQL, tt.pivotBy)
+			if res.Error == nil || !strings.Contains(res.Error.Error(), tt.message) {
+				t.Fatalf("expected an error containing %q, got %v", tt.message, res.Error)
+			}
+		})
+	}
+}
+
+func TestPivotQueryCached(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "cacheTtlSeconds": 3600}`)
+	rawSQL := `SELECT * FROM (VALUES
+		(TIMESTAMP '2024-03-10 10:00:00', 'web', 1.5),
+		(TIMESTAMP '2024-03-10 10:00:00', 'db', 2.0)
+	) t(time, host, cpu)`
+	query := func(model map[string]any) []string {
+		t.Helper()
+		model["rawSql"] = rawSQL
+		model["format"] = 1
+		raw, err := json.Marshal(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := runDataQuery(t, ds, backend.DataQuery{JSON: raw, QueryType: model["queryType"].(string)})
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		return fieldNames(res.Frames[0])
+	}
+
+	// The same SQL must not be served from the entry of a differently shaped
+	// result, in either order.
+	if names := query(map[string]any{"queryType": ""}); !reflect.DeepEqual(names, []string{"time", "host", "cpu"}) {
+		t.Fatalf("expected the long result, got %v", names)
+	}
+	if names := query(map[string]any{"queryType": "", "pivotBy": "host"}); !reflect.DeepEqual(names, []string{"time", "db", "web"}) {
+		t.Fatalf("expected the pivoted result, got %v", names)
+	}
+	if names := query(map[string]any{"queryType": annotationQueryType}); !reflect.DeepEqual(names, []string{"time", "text", "tags"}) {
+		t.Fatalf("expected the annotation result, got %v", names)
+	}
+	if names := query(map[string]any{"queryType": "", "pivotBy": "host"}); !reflect.DeepEqual(names, []string{"time", "db", "web"}) {
+		t.Fatalf("expected the cached pivoted result, got %v", names)
+	}
+	if ds.cache.Len() != 3 {
+		t.Errorf("expected an entry per query shape, got %d", ds.cache.Len())
+	}
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// readOnlyKeywords are the statements that may run in query only mode. WITH
+// and EXPLAIN are resolved to the statement they wrap first, as both can wrap
+// an INSERT. FROM starts DuckDB's FROM-first SELECT.
+var readOnlyKeywords = map[string]bool{
+	"SELECT":    true,
+	"FROM":      true,
+	"VALUES":    true,
+	"SHOW":      true,
+	"DESCRIBE":  true,
+	"SUMMARIZE": true,
+	"PIVOT":     true,
+	"UNPIVOT":   true,
+}
+
+// checkQueryOnly returns a ConfigError for the first statement of rawSQL that
+// is not a query, before anything is executed.
+func checkQueryOnly(rawSQL string) error {
+	for _, stmt := range splitStatements(rawSQL) {
+		keyword := statementKeyword(stmt)
+		if readOnlyKeywords[keyword] {
+			continue
+		}
+		if keyword == "" {
+			keyword = "this"
+		}
+		return &ConfigError{fmt.Sprintf("%s statements are not allowed in query only mode, only SELECT, WITH, SHOW, DESCRIBE and EXPLAIN queries can run", keyword)}
+	}
+	return nil
+}
+
+// checkQueryOnlyModel runs checkQueryOnly on the rawSql of a query model.
+// Models that fail to parse are left for sqlds to report.
+func checkQueryOnlyModel(query backend.DataQuery) error {
+	var model struct {
+		RawSQL string `json:"rawSql"`
+	}
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		return nil
+	}
+	return checkQueryOnly(model.RawSQL)
+}
+
+// statementKeyword returns the upper case keyword that decides what a
+// statement does, looking through CTEs and EXPLAIN. It returns an empty string
+// when the statement cannot be parsed.
+func statementKeyword(stmt string) string {
+	tokens := topLevelTokens(stmt)
+	i := 0
+	next := func() string {
+		if i >= len(tokens) {
+			return ""
+		}
+		i++
+		return tokens[i-1]
+	}
+
+	keyword := next()
+	for {
+		switch keyword {
+		case "WITH":
+			// WITH [RECURSIVE] name [(columns)] AS [[NOT] MATERIALIZED] (query) [, ...]
+			// leaves only the names and keywords at the top level.
+			if i < len(tokens) && tokens[i] == "RECURSIVE" {
+				i++
+			}
+			for {
+				next()
+				for t := next(); t != "AS"; t = next() {
+					if t == "" {
+						return ""
+					}
+				}
+				t := next()
+				if t == "NOT" {
+					t = next()
+				}
+				if t == "MATERIALIZED" {
+					t = next()
+				}
+				if t != "," {
+					keyword = t
+					break
+				}
+			}
+		case "EXPLAIN":
+			keyword = next()
+			if keyword == "ANALYZE" || keyword == "ANALYSE" {
+				keyword = next()
+			}
+		default:
+			return keyword
+		}
+	}
+}
+
+// topLevelTokens returns the words and commas of stmt outside of parentheses,
+// string literals and comments. Unquoted words are upper cased, quoted
+// identifiers keep their quotes so they never match a keyword. Parentheses
+// around the whole statement are dropped.
+func topLevelTokens(stmt string) []string {
+	stmt = leadingCommentsRegex.ReplaceAllString(stmt, "")
+	for strings.HasPrefix(stmt, "(") {
+		stmt = leadingCommentsRegex.ReplaceAllString(stmt[1:], "")
+	}
+
+	tokens := []string{}
+	depth := 0
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case c == '\'':
+			i = skipQuoted(stmt, i, c) - 1
+		case c == '"':
+			end := skipQuoted(stmt, i, c)
+			if depth == 0 {
+				tokens = append(tokens, stmt[i:end])
+			}
+			i = end - 1
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += 2 + end + 1
+		case c == '$':
+			tag, ok := dollarQuoteTag(stmt[i:])
+			if !ok {
+				continue
+			}
+			end := strings.Index(stmt[i+len(tag):], tag)
+			if end < 0 {
+				return tokens
+			}
+			i += len(tag) + end + len(tag) - 1
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth = max(depth-1, 0)
+		case c == ',' && depth == 0:
+			tokens = append(tokens, ",")
+		case isWordStart(c):
+			end := i + 1
+			for end < len(stmt) && (isWordStart(stmt[end]) || stmt[end] >= '0' && stmt[end] <= '9' || stmt[end] == '$') {
+				end++
+			}
+			if depth == 0 {
+				tokens = append(tokens, strings.ToUpper(stmt[i:end]))
+			}
+			i = end - 1
+		case c >= '0' && c <= '9':
+			// Skip numbers so digits never start a word.
+			for i+1 < len(stmt) && (stmt[i+1] >= '0' && stmt[i+1] <= '9' || isWordStart(stmt[i+1]) || stmt[i+1] == '.') {
+				i++
+			}
+		}
+	}
+	return tokens
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCheckQueryOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawSQL  string
+		allowed bool
+	}{
+		{"select", "SELECT 1", true},
+		{"lower case", "select * from t", true},
+		{"cte", "WITH x AS (SELECT 1 AS a), y(b) AS MATERIALIZED (SELECT 2) SELECT * FROM x, y", true},
+		{"recursive cte", "WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t WHERE n < 3) SELECT n FROM t", true},
+		{"from first", "FROM t", true},
+		{"parenthesized", "(SELECT 1) UNION (SELECT 2)", true},
+		{"show", "SHOW TABLES", true},
+		{"describe", "DESCRIBE t", true},
+		{"explain", "EXPLAIN SELECT 1", true},
+		{"explain analyze", "EXPLAIN ANALYZE SELECT 1", true},
+		{"line comment", "-- INSERT INTO t\nSELECT 1", true},
+		{"block comment", "/* DELETE FROM t; */\n  SELECT 1", true},
+		{"keyword in literal", "SELECT 'DROP TABLE t'", true},
+		{"several queries", "SELECT 1; SELECT 2;", true},
+		{"insert", "INSERT INTO t VALUES (1)", false},
+		{"update", "UPDATE t SET a = 1", false},
+		{"delete", "delete from t", false},
+		{"attach", "ATTACH 'other.db'", false},
+		{"create", "CREATE TABLE t (a INTEGER)", false},
+		{"copy", "COPY t TO 'out.csv'", false},
+		{"insert after a query", "SELECT 1; DROP TABLE t", false},
+		{"insert in cte", "WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", false},
+		{"explain analyze insert", "EXPLAIN ANALYZE INSERT INTO t VALUES (1)", false},
+		{"comment before insert", "/* report */ -- daily\nINSERT INTO t VALUES (1)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQueryOnly(tt.rawSQL)
+			if tt.allowed && err != nil {
+				t.Errorf("expected %q to be allowed, got %v", tt.rawSQL, err)
+			}
+			if !tt.allowed {
+				var configErr *ConfigError
+				if !errors.As(err, &configErr) {
+					t.Errorf("expected %q to be rejected with a config error, got %v", tt.rawSQL, err)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryOnly(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "queryOnly": true, "forwardHeaders": true}`)
+
+	if res := runQuery(t, ds, "-- totals\nSELECT 1 AS one"); res.Error != nil {
+		t.Fatalf("expected the query to run, got %v", res.Error)
+	}
+	res := runQuery(t, ds, "CREATE TABLE t AS SELECT 1")
+	if res.Error == nil || res.Status != backend.StatusBadRequest {
+		t.Fatalf("expected a bad request error, got %v %v", res.Status, res.Error)
+	}
+	if res := runQuery(t, ds, "SELECT * FROM t"); res.Error == nil {
+		t.Errorf("expected the rejected statement not to run")
+	}
+}
@@ -0,0 +1,559 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/sqlds/v3"
+)
+
+// resourceRoutes returns the resource endpoints served in addition to the
+// default sqlds ones (/tables, /schemas and /columns).
+func (d *SQLDataSourceWrapper) resourceRoutes() map[string]func(http.ResponseWriter, *http.Request) {
+	return map[string]func(http.ResponseWriter, *http.Request){
+		"/relationships":  d.handleRelationships,
+		"/catalog":        d.handleCatalog,
+		"POST /explain":   d.handleExplain,
+		"POST /summarize": d.handleSummarize,
+		"GET /tables/{database}/{schema}/{table}/preview":   d.handleTablePreview,
+		"GET /tables/{database}/{schema}/{table}/summarize": d.handleTableSummarize,
+	}
+}
+
+// CallResource rejects resource calls with the configuration error, if any,
+// and serves them through sqlds otherwise.
+func (d *SQLDataSourceWrapper) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if d.configErr != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(d.configErr.Error()),
+		})
+	}
+	return d.SQLDatasource.CallResource(ctx, req, sender)
+}
+
+func (d *SQLDataSourceWrapper) defaultDB(ctx context.Context) (*sql.DB, error) {
+	return d.GetDBFromQuery(ctx, &sqlds.Query{})
+}
+
+func writeResourceError(rw http.ResponseWriter, status int, err error) {
+	rw.WriteHeader(status)
+	if _, err := rw.Write([]byte(err.Error())); err != nil {
+		backend.Logger.Error("failed to write resource response", "error", err)
+	}
+}
+
+func writeResourceJSON(rw http.ResponseWriter, res any) {
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+	}
+}
+
+type PrimaryKey struct {
+	Database string   `json:"database"`
+	Schema   string   `json:"schema"`
+	Table    string   `json:"table"`
+	Columns  []string `json:"columns"`
+}
+
+// ForeignKey references a table in the same database and schema, DuckDB does
+// not support foreign keys across schemas.
+type ForeignKey struct {
+	Database          string   `json:"database"`
+	Schema            string   `json:"schema"`
+	Table             string   `json:"table"`
+	Columns           []string `json:"columns"`
+	ReferencedTable   string   `json:"referencedTable"`
+	ReferencedColumns []string `json:"referencedColumns"`
+}
+
+type Relationships struct {
+	PrimaryKeys []PrimaryKey `json:"primaryKeys"`
+	ForeignKeys []ForeignKey `json:"foreignKeys"`
+}
+
+const relationshipsQuery = `SELECT database_name, schema_name, table_name, constraint_type,
+	constraint_column_names, referenced_table, referenced_column_names
+FROM duckdb_constraints()
+WHERE constraint_type IN ('PRIMARY KEY', 'FOREIGN KEY')
+ORDER BY database_name, schema_name, table_name, constraint_index`
+
+// GetRelationships lists the primary and foreign keys of all attached databases.
+func GetRelationships(ctx context.Context, db *sql.DB) (*Relationships, error) {
+	rows, err := db.QueryContext(ctx, relationshipsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := &Relationships{PrimaryKeys: []PrimaryKey{}, ForeignKeys: []ForeignKey{}}
+	for rows.Next() {
+		var database, schema, table, constraintType string
+		var columns, referencedColumns []any
+		var referencedTable sql.NullString
+		if err := rows.Scan(&database, &schema, &table, &constraintType, &columns, &referencedTable, &referencedColumns); err != nil {
+			return nil, err
+		}
+		switch constraintType {
+		case "PRIMARY KEY":
+			res.PrimaryKeys = append(res.PrimaryKeys, PrimaryKey{
+				Database: database,
+				Schema:   schema,
+				Table:    table,
+				Columns:  toStringSlice(columns),
+			})
+		case "FOREIGN KEY":
+			res.ForeignKeys = append(res.ForeignKeys, ForeignKey{
+				Database:          database,
+				Schema:            schema,
+				Table:             table,
+				Columns:           toStringSlice(columns),
+				ReferencedTable:   referencedTable.String,
+				ReferencedColumns: toStringSlice(referencedColumns),
+			})
+		}
+	}
+	return res, rows.Err()
+}
+
+func toStringSlice(values []any) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		res = append(res, fmt.Sprint(v))
+	}
+	return res
+}
+
+func (d *SQLDataSourceWrapper) handleRelationships(rw http.ResponseWriter, req *http.Request) {
+	db, err := d.defaultDB(req.Context())
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := GetRelationships(req.Context(), db)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, res)
+}
+
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+}
+
+type Schema struct {
+	Name   string  `json:"name"`
+	Tables []Table `json:"tables"`
+}
+
+type Database struct {
+	Name    string   `json:"name"`
+	Schemas []Schema `json:"schemas"`
+}
+
+type Catalog struct {
+	Databases []Database `json:"databases"`
+}
+
+// catalogQuery lists the attached databases, their schemas and the columns of
+// their tables. Databases and schemas come with NULL for the levels below, so
+// the ones without tables are listed as well, and are ordered first.
+const catalogQuery = `SELECT database_name, NULL AS schema_name, NULL AS table_name, NULL AS column_name, NULL AS data_type, NULL AS column_index
+FROM duckdb_databases()
+WHERE NOT internal
+UNION ALL
+SELECT s.database_name, s.schema_name, NULL, NULL, NULL, NULL
+FROM duckdb_schemas() s JOIN duckdb_databases() d ON s.database_oid = d.database_oid
+WHERE NOT d.internal
+UNION ALL
+SELECT database_name, schema_name, table_name, column_name, data_type, column_index
+FROM duckdb_columns()
+WHERE NOT internal
+ORDER BY database_name, schema_name NULLS FIRST, table_name NULLS FIRST, column_index NULLS FIRST`
+
+// GetCatalog lists the tables and views of all attached databases, grouped by
+// database and schema, with their columns. Databases and schemas without
+// tables are listed too.
+func GetCatalog(ctx context.Context, db *sql.DB) (*Catalog, error) {
+	rows, err := db.QueryContext(ctx, catalogQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := &Catalog{Databases: []Database{}}
+	for rows.Next() {
+		var database string
+		var schema, table, column, columnType sql.NullString
+		var index sql.NullInt64
+		if err := rows.Scan(&database, &schema, &table, &column, &columnType, &index); err != nil {
+			return nil, err
+		}
+
+		// Rows are ordered, so only the last entry of each level can match.
+		if n := len(res.Databases); n == 0 || res.Databases[n-1].Name != database {
+			res.Databases = append(res.Databases, Database{Name: database, Schemas: []Schema{}})
+		}
+		if !schema.Valid {
+			continue
+		}
+		dbEntry := &res.Databases[len(res.Databases)-1]
+		if n := len(dbEntry.Schemas); n == 0 || dbEntry.Schemas[n-1].Name != schema.String {
+			dbEntry.Schemas = append(dbEntry.Schemas, Schema{Name: schema.String, Tables: []Table{}})
+		}
+		if !table.Valid {
+			continue
+		}
+		sc := &dbEntry.Schemas[len(dbEntry.Schemas)-1]
+		if n := len(sc.Tables); n == 0 || sc.Tables[n-1].Name != table.String {
+			sc.Tables = append(sc.Tables, Table{Name: table.String, Columns: []Column{}})
+		}
+		tbl := &sc.Tables[len(sc.Tables)-1]
+		tbl.Columns = append(tbl.Columns, Column{Name: column.String, Type: columnType.String})
+	}
+	return res, rows.Err()
+}
+
+func (d *SQLDataSourceWrapper) handleCatalog(rw http.ResponseWriter, req *http.Request) {
+	db, err := d.defaultDB(req.Context())
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := GetCatalog(req.Context(), db)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, res)
+}
+
+const (
+	defaultPreviewRows = 10
+	maxPreviewRows     = 1000
+)
+
+// GetTablePreview returns the first rows of a table or view, converted like
+// query results. The names are quoted, so they can't inject SQL.
+func GetTablePreview(ctx context.Context, db *sql.DB, converters []sqlutil.Converter, database, schema, table string, limit int) (*data.Frame, error) {
+	query := fmt.Sprintf("SELECT * FROM %s.%s.%s LIMIT %d",
+		quoteIdentifier(database), quoteIdentifier(schema), quoteIdentifier(table), limit)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	frame, err := sqlutil.FrameFromRows(rows, int64(limit), converters...)
+	if err != nil {
+		return nil, err
+	}
+	frame.Name = table
+	return frame, nil
+}
+
+// handleTablePreview serves /tables/<database>/<schema>/<table>/preview. The
+// limit parameter defaults to 10 rows and is capped at 1000.
+func (d *SQLDataSourceWrapper) handleTablePreview(rw http.ResponseWriter, req *http.Request) {
+	limit := defaultPreviewRows
+	if value := req.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("invalid limit %q: expected a positive number", value))
+			return
+		}
+		limit = min(n, maxPreviewRows)
+	}
+
+	db, err := d.defaultDB(req.Context())
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	frame, err := GetTablePreview(req.Context(), db, d.driver.Converters(),
+		req.PathValue("database"), req.PathValue("schema"), req.PathValue("table"), limit)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, frame)
+}
+
+// GetSummary runs SUMMARIZE on target and returns the statistics of its
+// columns, one row per column, converted like query results. target is either
+// a quoted table name or a SELECT wrapped by summarizeQuery.
+func GetSummary(ctx context.Context, db *sql.DB, converters []sqlutil.Converter, target, name string) (*data.Frame, error) {
+	rows, err := db.QueryContext(ctx, "SUMMARIZE "+target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	frame, err := sqlutil.FrameFromRows(rows, -1, converters...)
+	if err != nil {
+		return nil, err
+	}
+	frame.Name = name
+	return frame, nil
+}
+
+// summarizeQuery wraps query in a SELECT, so only a single query can be
+// summarized. The closing parenthesis goes on its own line in case query ends
+// with a line comment.
+func summarizeQuery(query string) (string, error) {
+	statements := splitStatements(query)
+	if len(statements) != 1 {
+		return "", fmt.Errorf("expected a single query to summarize, got %d statements", len(statements))
+	}
+	if keyword := statementKeyword(statements[0]); !readOnlyKeywords[keyword] {
+		if keyword == "" {
+			keyword = "this"
+		}
+		return "", fmt.Errorf("%s statements cannot be summarized, expected a query", keyword)
+	}
+	return "SELECT * FROM (\n" + statements[0] + "\n)", nil
+}
+
+// handleTableSummarize serves /tables/<database>/<schema>/<table>/summarize.
+// The names are quoted, so they can't inject SQL.
+func (d *SQLDataSourceWrapper) handleTableSummarize(rw http.ResponseWriter, req *http.Request) {
+	db, err := d.defaultDB(req.Context())
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	target := fmt.Sprintf("%s.%s.%s", quoteIdentifier(req.PathValue("database")),
+		quoteIdentifier(req.PathValue("schema")), quoteIdentifier(req.PathValue("table")))
+	frame, err := GetSummary(req.Context(), db, d.driver.Converters(), target, req.PathValue("table"))
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, frame)
+}
+
+// SummarizeRequest is the body of /summarize.
+type SummarizeRequest struct {
+	Query string `json:"query"`
+}
+
+// handleSummarize serves POST /summarize, which profiles the result of a
+// query. Only a single query is accepted, whatever the queryOnly setting.
+func (d *SQLDataSourceWrapper) handleSummarize(rw http.ResponseWriter, req *http.Request) {
+	var body SummarizeRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("invalid summarize request: %w", err))
+		return
+	}
+	target, err := summarizeQuery(body.Query)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	db, err := d.defaultDB(req.Context())
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	frame, err := GetSummary(req.Context(), db, d.driver.Converters(), target, "summary")
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, frame)
+}
+
+// defaultExplainRange is the time range the macros of an explained query are
+// expanded with when the request does not send one.
+const defaultExplainRange = time.Hour
+
+// ExplainRequest is the body of /explain. Query is a query model as sent to
+// QueryData, From and To are RFC 3339 times or unix epoch milliseconds.
+type ExplainRequest struct {
+	Query         json.RawMessage `json:"query"`
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+}
+
+// Explain is the plan of a query, or the error it fails to parse or bind
+// with. SQL is the query after macro expansion.
+type Explain struct {
+	SQL   string `json:"sql"`
+	Plan  string `json:"plan,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// parseExplainTime parses a time of an ExplainRequest, def is used when it
+// is empty.
+func parseExplainTime(value string, def time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or unix epoch milliseconds", value)
+	}
+	return t, nil
+}
+
+// explainQuery builds the data query of an ExplainRequest. Without a time
+// range the last hour is used.
+func explainQuery(body []byte) (backend.DataQuery, error) {
+	var req ExplainRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return backend.DataQuery{}, fmt.Errorf("invalid explain request: %w", err)
+	}
+	if len(req.Query) == 0 {
+		return backend.DataQuery{}, fmt.Errorf("invalid explain request: missing query")
+	}
+	now := time.Now().UTC()
+	to, err := parseExplainTime(req.To, now)
+	if err != nil {
+		return backend.DataQuery{}, err
+	}
+	from, err := parseExplainTime(req.From, to.Add(-defaultExplainRange))
+	if err != nil {
+		return backend.DataQuery{}, err
+	}
+	if from.After(to) {
+		return backend.DataQuery{}, fmt.Errorf("invalid time range: from %s is after to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return backend.DataQuery{
+		RefID:         "A",
+		JSON:          req.Query,
+		TimeRange:     backend.TimeRange{From: from, To: to},
+		Interval:      time.Duration(req.IntervalMs) * time.Millisecond,
+		MaxDataPoints: req.MaxDataPoints,
+	}, nil
+}
+
+// ExplainQuery returns the plan DuckDB would run sql with. Only the last
+// statement is explained, it is the one whose result QueryData returns, and
+// the statements before it are not run. Parse and bind errors are returned
+// as the error of the Explain rather than as an error.
+func ExplainQuery(ctx context.Context, db *sql.DB, query string, params []any) (*Explain, error) {
+	res := &Explain{SQL: query}
+	statements := splitStatements(query)
+	if len(statements) == 0 {
+		res.Error = "the query is empty"
+		return res, nil
+	}
+	rows, err := db.QueryContext(ctx, "EXPLAIN "+statements[len(statements)-1], params...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		res.Error = err.Error()
+		return res, nil
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		plan = append(plan, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	res.Plan = strings.Join(plan, "\n")
+	return res, nil
+}
+
+// handleExplain serves POST /explain. The macros of the query are expanded
+// like in QueryData, with the time range of the request, and the query is
+// explained without running it. The response has status 200 for queries
+// that fail to parse or bind, with the DuckDB error in its error field.
+func (d *SQLDataSourceWrapper) handleExplain(rw http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	query, err := explainQuery(body)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if d.queryOnly {
+		if err := checkQueryOnlyModel(query); err != nil {
+			writeResourceJSON(rw, &Explain{Error: err.Error()})
+			return
+		}
+	}
+	if d.useQueryTimezone {
+		// The time range is moved to the timezone of the query, like in QueryData.
+		tzReq, err := withQueryTimezone(&backend.QueryDataRequest{Queries: []backend.DataQuery{query}})
+		if err != nil {
+			writeResourceError(rw, http.StatusBadRequest, err)
+			return
+		}
+		query = tzReq.Queries[0]
+	}
+
+	q, err := sqlds.GetQuery(query, req.Header, d.DriverSettings().ForwardHeaders)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	expanded, err := sqlds.Interpolate(d.driver, q)
+	if err != nil {
+		writeResourceJSON(rw, &Explain{SQL: q.RawSQL, Error: err.Error()})
+		return
+	}
+	params, err := queryParams(query)
+	if err != nil {
+		writeResourceJSON(rw, &Explain{SQL: expanded, Error: err.Error()})
+		return
+	}
+	timeout, err := d.timeoutFor(query)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := req.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	db, err := d.defaultDB(ctx)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := ExplainQuery(ctx, db, expanded, params)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+	writeResourceJSON(rw, res)
+}
@@ -0,0 +1,325 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func newTestDatasource(t *testing.T, jsonData string) *SQLDataSourceWrapper {
+	t.Helper()
+	ds := NewDatasource(&DuckDBDriver{Initialized: false})
+	_, err := ds.NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(jsonData),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func callResource(t *testing.T, ds *SQLDataSourceWrapper, path string, body []byte) *backend.CallResourceResponse {
+	t.Helper()
+	return callResourceMethod(t, ds, http.MethodGet, path, body)
+}
+
+func callResourceMethod(t *testing.T, ds *SQLDataSourceWrapper, method, path string, body []byte) *backend.CallResourceResponse {
+	t.Helper()
+	var res *backend.CallResourceResponse
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		Method: method,
+		Path:   strings.Split(path, "?")[0],
+		URL:    path,
+		Body:   body,
+	}, backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+		res = r
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil {
+		t.Fatal("no resource response sent")
+	}
+	return res
+}
+
+func TestRelationshipsResource(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "initSql": "CREATE TABLE customers(id INTEGER PRIMARY KEY, name VARCHAR); CREATE TABLE orders(id INTEGER, customer_id INTEGER REFERENCES customers(id), PRIMARY KEY (id));"}`)
+
+	res := callResource(t, ds, "relationships", nil)
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Status, res.Body)
+	}
+
+	var rel Relationships
+	if err := json.Unmarshal(res.Body, &rel); err != nil {
+		t.Fatal(err)
+	}
+	expectedPKs := []PrimaryKey{
+		{Database: "memory", Schema: "main", Table: "customers", Columns: []string{"id"}},
+		{Database: "memory", Schema: "main", Table: "orders", Columns: []string{"id"}},
+	}
+	if !reflect.DeepEqual(rel.PrimaryKeys, expectedPKs) {
+		t.Errorf("expected primary keys %+v, got %+v", expectedPKs, rel.PrimaryKeys)
+	}
+	expectedFKs := []ForeignKey{
+		{Database: "memory", Schema: "main", Table: "orders", Columns: []string{"customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"id"}},
+	}
+	if !reflect.DeepEqual(rel.ForeignKeys, expectedFKs) {
+		t.Errorf("expected foreign keys %+v, got %+v", expectedFKs, rel.ForeignKeys)
+	}
+}
+
+func TestRelationshipsResourceWithoutConstraints(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":""}`)
+
+	res := callResource(t, ds, "relationships", nil)
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Status, res.Body)
+	}
+	if string(res.Body) != "{\"primaryKeys\":[],\"foreignKeys\":[]}\n" {
+		t.Errorf("expected empty relationships, got %s", res.Body)
+	}
+}
+
+func TestCatalogResource(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE SCHEMA sales; CREATE TABLE sales.orders(id INTEGER, total DECIMAL(10,2))")
+	ds := newTestDatasource(t, fmt.Sprintf(`{"path":"", "initSql": "CREATE TABLE users(id INTEGER, name VARCHAR); CREATE VIEW names AS SELECT name FROM users; CREATE SCHEMA staging; ATTACH ':memory:' AS scratch;", "attachments": [{"alias": "other", "path": %q, "readOnly": true}]}`, path))
+
+	res := callResource(t, ds, "catalog", nil)
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Status, res.Body)
+	}
+
+	var catalog Catalog
+	if err := json.Unmarshal(res.Body, &catalog); err != nil {
+		t.Fatal(err)
+	}
+	expected := Catalog{Databases: []Database{
+		{Name: "memory", Schemas: []Schema{
+			{Name: "main", Tables: []Table{
+				{Name: "names", Columns: []Column{{Name: "name", Type: "VARCHAR"}}},
+				{Name: "users", Columns: []Column{{Name: "id", Type: "INTEGER"}, {Name: "name", Type: "VARCHAR"}}},
+			}},
+			// Schemas and databases without tables are listed too.
+			{Name: "staging", Tables: []Table{}},
+		}},
+		{Name: "other", Schemas: []Schema{
+			{Name: "main", Tables: []Table{}},
+			{Name: "sales", Tables: []Table{
+				{Name: "orders", Columns: []Column{{Name: "id", Type: "INTEGER"}, {Name: "total", Type: "DECIMAL(10,2)"}}},
+			}},
+		}},
+		{Name: "scratch", Schemas: []Schema{{Name: "main", Tables: []Table{}}}},
+	}}
+	if !reflect.DeepEqual(catalog, expected) {
+		t.Errorf("expected %+v, got %+v", expected, catalog)
+	}
+}
+
+func TestCatalogResourceEmpty(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":""}`)
+
+	res := callResource(t, ds, "catalog", nil)
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Status, res.Body)
+	}
+	if string(res.Body) != `{"databases":[{"name":"memory","schemas":[{"name":"main","tables":[]}]}]}`+"\n" {
+		t.Errorf("expected only the empty main schema, got %s", res.Body)
+	}
+}
+
+func previewRows(t *testing.T, ds *SQLDataSourceWrapper, path string) *data.Frame {
+	t.Helper()
+	res := callResource(t, ds, path, nil)
+	if res.Status != http.StatusOK {
+		t.Fatalf("%s: expected status 200, got %d: %s", path, res.Status, res.Body)
+	}
+	frame := &data.Frame{}
+	if err := json.Unmarshal(res.Body, frame); err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestTablePreviewResource(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "initSql": "CREATE TABLE numbers AS SELECT range AS n, TIMESTAMPTZ '2024-01-01 00:00:00+02' AS ts FROM range(1500);"}`)
+
+	frame := previewRows(t, ds, "tables/memory/main/numbers/preview?limit=3")
+	assertField(t, frame, "n", data.FieldTypeNullableInt64, []any{int64(0), int64(1), int64(2)})
+	// Values go through the converters like query results.
+	assertField(t, frame, "ts", data.FieldTypeNullableTime, []any{
+		time.Date(2023, 12, 31, 22, 0, 0, 0, time.UTC),
+		time.Date(2023, 12, 31, 22, 0, 0, 0, time.UTC),
+		time.Date(2023, 12, 31, 22, 0, 0, 0, time.UTC),
+	})
+
+	if rows, _ := previewRows(t, ds, "tables/memory/main/numbers/preview").RowLen(); rows != defaultPreviewRows {
+		t.Errorf("expected %d rows by default, got %d", defaultPreviewRows, rows)
+	}
+	if rows, _ := previewRows(t, ds, "tables/memory/main/numbers/preview?limit=5000").RowLen(); rows != maxPreviewRows {
+		t.Errorf("expected the limit to be capped at %d rows, got %d", maxPreviewRows, rows)
+	}
+
+	for _, limit := range []string{"0", "-1", "ten"} {
+		if res := callResource(t, ds, "tables/memory/main/numbers/preview?limit="+limit, nil); res.Status != http.StatusBadRequest {
+			t.Errorf("limit %s: expected status 400, got %d", limit, res.Status)
+		}
+	}
+}
+
+func TestTablePreviewResourceIdentifiers(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "initSql": "CREATE TABLE \"it's \"\"quoted\"\"\" AS SELECT 1 AS x; CREATE TABLE t AS SELECT 1 AS x;"}`)
+
+	frame := previewRows(t, ds, "tables/memory/main/"+url.PathEscape(`it's "quoted"`)+"/preview")
+	assertField(t, frame, "x", data.FieldTypeNullableInt32, []any{int32(1)})
+
+	for _, table := range []string{`t" ; DROP TABLE t; --`, `t LIMIT 0; DROP TABLE t; --`} {
+		res := callResource(t, ds, "tables/memory/main/"+url.PathEscape(table)+"/preview", nil)
+		if res.Status != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", table, res.Status, res.Body)
+		}
+	}
+	if res := callResource(t, ds, "tables/memory/main/t/preview", nil); res.Status != http.StatusOK {
+		t.Errorf("expected table t to still exist, got %d: %s", res.Status, res.Body)
+	}
+}
+
+func explain(t *testing.T, ds *SQLDataSourceWrapper, body string) Explain {
+	t.Helper()
+	res := callResourceMethod(t, ds, http.MethodPost, "explain", []byte(body))
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Status, res.Body)
+	}
+	var explain Explain
+	if err := json.Unmarshal(res.Body, &explain); err != nil {
+		t.Fatal(err)
+	}
+	return explain
+}
+
+func TestExplainResource(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "initSql": "CREATE TABLE events(ts TIMESTAMP, n INTEGER);"}`)
+
+	res := explain(t, ds, `{
+		"query": {"rawSql": "SELECT count(*) FROM events WHERE $__timeFilter(ts) AND n > ?", "params": [3]},
+		"from": "2024-01-01T00:00:00Z",
+		"to": "1704070800000"
+	}`)
+	if res.Error != "" {
+		t.Fatalf("expected a plan, got error %s", res.Error)
+	}
+	if !strings.Contains(res.Plan, "SEQ_SCAN") || !strings.Contains(res.Plan, "events") {
+		t.Errorf("expected a plan scanning events, got %s", res.Plan)
+	}
+	// The macros are expanded like in QueryData.
+	if !strings.Contains(res.SQL, `ts >= '2024-01-01T00:00:00Z' AND ts <= '2024-01-01T01:00:00Z'`) {
+		t.Errorf("expected the time filter to be expanded, got %s", res.SQL)
+	}
+
+	// Without a time range the macros are expanded with the last hour.
+	if res := explain(t, ds, `{"query": {"rawSql": "SELECT * FROM events WHERE $__timeFilter(ts)"}}`); res.Error != "" || strings.Contains(res.SQL, "$__") {
+		t.Errorf("expected the default time range to be used, got %+v", res)
+	}
+
+	// Nothing is run: the explained statement does not insert a row.
+	explain(t, ds, `{"query": {"rawSql": "INSERT INTO events VALUES (now(), 1)"}}`)
+	dr := runQuery(t, ds, "SELECT count(*) AS n FROM events")
+	if dr.Error != nil {
+		t.Fatal(dr.Error)
+	}
+	assertField(t, dr.Frames[0], "n", data.FieldTypeNullableInt64, []any{int64(0)})
+}
+
+func TestExplainResourceErrors(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":""}`)
+
+	res := explain(t, ds, `{"query": {"rawSql": "SELECT * FROM missing_table"}}`)
+	if res.Plan != "" || !strings.Contains(res.Error, "Table with name missing_table does not exist") {
+		t.Errorf("expected the bind error, got %+v", res)
+	}
+	res = explain(t, ds, `{"query": {"rawSql": "SELEC 1"}}`)
+	if !strings.Contains(res.Error, "Parser Error") {
+		t.Errorf("expected the parse error, got %+v", res)
+	}
+	res = explain(t, ds, `{"query": {"rawSql": "SELECT $__timeGroup(ts)"}}`)
+	if res.Error == "" {
+		t.Errorf("expected the macro error, got %+v", res)
+	}
+
+	for _, body := range []string{`not json`, `{}`, `{"query": {"rawSql": "SELECT 1"}, "from": "yesterday"}`, `{"query": {"rawSql": "SELECT 1"}, "from": "2024-01-02T00:00:00Z", "to": "2024-01-01T00:00:00Z"}`} {
+		if res := callResourceMethod(t, ds, http.MethodPost, "explain", []byte(body)); res.Status != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", body, res.Status, res.Body)
+		}
+	}
+}
+
+func summarize(t *testing.T, res *backend.CallResourceResponse) *data.Frame {
+	t.Helper()
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.Status, res.Body)
+	}
+	var frame data.Frame
+	if err := json.Unmarshal(res.Body, &frame); err != nil {
+		t.Fatal(err)
+	}
+	return &frame
+}
+
+func TestSummarizeResource(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "initSql": "CREATE TABLE numbers AS SELECT range AS n, 'x' || range AS s, NULL::INTEGER AS z FROM range(5);"}`)
+
+	frame := summarize(t, callResource(t, ds, "tables/memory/main/numbers/summarize", nil))
+	if frame.Name != "numbers" {
+		t.Errorf("expected the frame to be named after the table, got %q", frame.Name)
+	}
+	var names []string
+	for _, field := range frame.Fields {
+		names = append(names, field.Name)
+	}
+	expected := []string{"column_name", "column_type", "min", "max", "approx_unique", "avg", "std", "q25", "q50", "q75", "count", "null_percentage"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected the stat columns %v, got %v", expected, names)
+	}
+	assertField(t, frame, "column_name", data.FieldTypeNullableString, []any{"n", "s", "z"})
+	assertField(t, frame, "min", data.FieldTypeNullableString, []any{"0", "x0", nil})
+	assertField(t, frame, "max", data.FieldTypeNullableString, []any{"4", "x4", nil})
+	assertField(t, frame, "count", data.FieldTypeNullableInt64, []any{int64(5), int64(5), int64(5)})
+	assertField(t, frame, "null_percentage", data.FieldTypeNullableFloat64, []any{0.0, 0.0, 100.0})
+
+	frame = summarize(t, callResourceMethod(t, ds, http.MethodPost, "summarize", []byte(`{"query": "SELECT n FROM numbers WHERE n > 2 -- big ones"}`)))
+	assertField(t, frame, "column_name", data.FieldTypeNullableString, []any{"n"})
+	assertField(t, frame, "count", data.FieldTypeNullableInt64, []any{int64(2)})
+}
+
+func TestSummarizeResourceRejectsStatements(t *testing.T) {
+	ds := newTestDatasource(t, `{"path":"", "initSql": "CREATE TABLE t AS SELECT 1 AS x;"}`)
+
+	for _, query := range []string{
+		"SELECT 1); DROP TABLE t; --",
+		"SELECT 1; SELECT 2",
+		"DROP TABLE t",
+		"WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x",
+		"",
+	} {
+		body, _ := json.Marshal(SummarizeRequest{Query: query})
+		if res := callResourceMethod(t, ds, http.MethodPost, "summarize", body); res.Status != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d: %s", query, res.Status, res.Body)
+		}
+	}
+	if res := callResource(t, ds, "tables/memory/main/"+url.PathEscape(`t" ; DROP TABLE t; --`)+"/summarize", nil); res.Status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d: %s", res.Status, res.Body)
+	}
+	frame := summarize(t, callResource(t, ds, "tables/memory/main/t/summarize", nil))
+	assertField(t, frame, "column_name", data.FieldTypeNullableString, []any{"x"})
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+// queryAttempts counts how often the connections ran a query. sqlds retries
+// failed queries on a new connection without telling the caller, the attempts
+// are counted by the connections, which see the context of every attempt.
+type queryAttempts struct {
+	mu      sync.Mutex
+	count   int
+	lastErr error
+}
+
+type queryAttemptsKey struct{}
+
+// withQueryAttempts returns a context whose query attempts are counted.
+func withQueryAttempts(ctx context.Context) (context.Context, *queryAttempts) {
+	attempts := &queryAttempts{}
+	return context.WithValue(ctx, queryAttemptsKey{}, attempts), attempts
+}
+
+// record counts an attempt and keeps its error, if any.
+func (a *queryAttempts) record(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.count++
+	if err != nil {
+		a.lastErr = err
+	}
+}
+
+// retries returns the number of attempts after the first and the error of the
+// last failed one.
+func (a *queryAttempts) retries() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return max(a.count-1, 0), a.lastErr
+}
+
+// queryRetries is the frame metadata of a query that only succeeded after
+// being retried.
+type queryRetries struct {
+	Retries   int    `json:"retries"`
+	LastError string `json:"lastError"`
+}
+
+// markRetried records the retries of a successful query in its frames, as
+// custom metadata and as a notice shown in the panel inspector.
+func markRetried(res backend.DataResponse, retries int, lastErr error) backend.DataResponse {
+	if res.Error != nil || retries == 0 {
+		return res
+	}
+	info := queryRetries{Retries: retries}
+	if lastErr != nil {
+		info.LastError = lastErr.Error()
+	}
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		if frame.Meta.Custom == nil {
+			frame.Meta.Custom = info
+		}
+		text := "Query succeeded after 1 retry"
+		if info.Retries > 1 {
+			text = fmt.Sprintf("Query succeeded after %d retries", info.Retries)
+		}
+		if info.LastError != "" {
+			text += ", last error: " + info.LastError
+		}
+		frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityInfo, Text: text})
+	}
+	return res
+}
+
+const (
+	defaultRemoteRetries      = 3
+	defaultRemoteRetryBackoff = 500 * time.Millisecond
+)
+
+// defaultRemoteRetryOn are the error substrings of httpfs and S3 failures that
+// usually go away by retrying: throttling, overloaded or restarting servers and
+// dropped connections. Access errors like 403 are not in the list.
+var defaultRemoteRetryOn = []string{
+	"HTTP 429", "HTTP 500", "HTTP 502", "HTTP 503", "HTTP 504",
+	"Too Many Requests", "Service Unavailable", "Bad Gateway", "Gateway Timeout", "SlowDown",
+	"Could not establish connection", "Connection reset", "timed out", "Timeout was reached",
+}
+
+// remoteRetry retries queries failing with a transient error of a remote
+// read. Unlike the retries of sqlds, which retry the errors of RetryOn on a new
+// connection whatever their kind, only IO and HTTP errors are retried, so
+// syntax and permission errors still fail fast.
+type remoteRetry struct {
+	retries int
+	backoff time.Duration
+	retryOn []string
+}
+
+// remoteRetryPolicy builds the remote retry policy of the settings.
+func remoteRetryPolicy(config *models.PluginSettings) (remoteRetry, error) {
+	policy := remoteRetry{retries: defaultRemoteRetries, backoff: defaultRemoteRetryBackoff, retryOn: defaultRemoteRetryOn}
+	if config.RemoteRetries != nil {
+		if *config.RemoteRetries < 0 {
+			return policy, &ConfigError{fmt.Sprintf("Invalid number of remote retries: %d -> must not be negative", *config.RemoteRetries)}
+		}
+		policy.retries = *config.RemoteRetries
+	}
+	if value := strings.TrimSpace(config.RemoteRetryBackoff); value != "" {
+		backoff, err := time.ParseDuration(value)
+		if err != nil || backoff < 0 {
+			return policy, &ConfigError{"Invalid remote retry backoff: " + value + " -> example input: 500ms"}
+		}
+		policy.backoff = backoff
+	}
+	if config.RemoteRetryOn != nil {
+		policy.retryOn = []string{}
+		for _, pattern := range config.RemoteRetryOn {
+			// An empty pattern would match every error.
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				policy.retryOn = append(policy.retryOn, pattern)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// isTransient reports whether err is an IO or HTTP error matching one of the
+// retryOn substrings, compared case-insensitively.
+func (r remoteRetry) isTransient(err error) bool {
+	var duckdbErr *duckdb.Error
+	if !errors.As(err, &duckdbErr) || (duckdbErr.Type != duckdb.ErrorTypeIO && duckdbErr.Type != duckdb.ErrorTypeHTTP) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range r.retryOn {
+		if strings.Contains(msg, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// do runs attempt, retrying transient errors with exponential backoff until
+// the retries are used up or ctx is done.
+func (r remoteRetry) do(ctx context.Context, attempt func() error) error {
+	backoff := r.backoff
+	for retry := 0; ; retry++ {
+		err := attempt()
+		if err == nil || retry >= r.retries || !r.isTransient(err) {
+			return err
+		}
+		queryLogger(ctx).Warn("Remote read failed, retrying", "attempt", retry+1, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+func TestQueryRetriesMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retries.duckdb")
+	// The sequence lives in the file, so it survives the reconnect before the
+	// retry: the first attempt fails and the second succeeds.
+	ds := newTestDatasource(t, fmt.Sprintf(`{"path": %q, "initSql": "CREATE SEQUENCE IF NOT EXISTS attempts", "retryOn": ["transient failure"], "retries": 2, "pause": 0}`, path))
+
+	res := runQuery(t, ds, "SELECT CASE WHEN nextval('attempts') = 1 THEN error('transient failure') ELSE 42 END AS answer")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	frame := res.Frames[0]
+	info, ok := frame.Meta.Custom.(queryRetries)
+	if !ok {
+		t.Fatalf("expected retry metadata, got %#v", frame.Meta.Custom)
+	}
+	if info.Retries != 1 || !strings.Contains(info.LastError, "transient failure") {
+		t.Errorf("expected 1 retry after the transient failure, got %+v", info)
+	}
+	if len(frame.Meta.Notices) != 1 || !strings.Contains(frame.Meta.Notices[0].Text, "succeeded after 1 retry,") {
+		t.Errorf("expected a retry notice, got %v", frame.Meta.Notices)
+	}
+
+	res = runQuery(t, ds, "SELECT 1 AS one")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if meta := res.Frames[0].Meta; meta != nil && (meta.Custom != nil || len(meta.Notices) > 0) {
+		t.Errorf("expected no retry metadata for a query that succeeded right away, got %+v", meta)
+	}
+}
+
+func TestMarkRetriedNotice(t *testing.T) {
+	for retries, expected := range map[int]string{
+		1: "Query succeeded after 1 retry",
+		3: "Query succeeded after 3 retries",
+	} {
+		res := markRetried(backend.DataResponse{Frames: data.Frames{data.NewFrame("")}}, retries, nil)
+		if notices := res.Frames[0].Meta.Notices; len(notices) != 1 || notices[0].Text != expected {
+			t.Errorf("expected %q, got %v", expected, notices)
+		}
+	}
+}
+
+func TestRemoteRetryTransientErrors(t *testing.T) {
+	policy := remoteRetry{retries: 3, backoff: time.Millisecond, retryOn: defaultRemoteRetryOn}
+
+	// A transient error followed by success.
+	unavailable := &duckdb.Error{Type: duckdb.ErrorTypeHTTP, Msg: "HTTP Error: HTTP GET error on 'https://bucket.s3.amazonaws.com/data.parquet' (HTTP 503)"}
+	calls := 0
+	err := policy.do(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return unavailable
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected success on the second attempt, got %d attempts, %v", calls, err)
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"persistent transient error", unavailable, 4},
+		{"connection error", &duckdb.Error{Type: duckdb.ErrorTypeIO, Msg: "IO Error: Could not establish connection"}, 4},
+		{"forbidden", &duckdb.Error{Type: duckdb.ErrorTypeHTTP, Msg: "HTTP Error: HTTP GET error on 'https://bucket.s3.amazonaws.com/data.parquet' (HTTP 403)"}, 1},
+		{"syntax error", &duckdb.Error{Type: duckdb.ErrorTypeParser, Msg: "Parser Error: syntax error at or near \"timed out\""}, 1},
+		{"other error", errors.New("HTTP 503"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := policy.do(context.Background(), func() error {
+				calls++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) || calls != tt.calls {
+				t.Errorf("expected %d attempts ending with the error, got %d attempts, %v", tt.calls, calls, err)
+			}
+		})
+	}
+
+	// A cancelled request stops waiting for the next retry.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	slow := remoteRetry{retries: 3, backoff: time.Hour, retryOn: defaultRemoteRetryOn}
+	if err := slow.do(ctx, func() error { calls++; return unavailable }); err != unavailable || calls != 1 {
+		t.Errorf("expected the cancelled request to stop retrying, got %d attempts, %v", calls, err)
+	}
+}
+
+func TestRemoteRetryPolicy(t *testing.T) {
+	zero := 0
+	negative := -1
+	policy, err := remoteRetryPolicy(&models.PluginSettings{})
+	if err != nil || policy.retries != defaultRemoteRetries || policy.backoff != defaultRemoteRetryBackoff {
+		t.Errorf("expected the defaults, got %+v, %v", policy, err)
+	}
+	policy, err = remoteRetryPolicy(&models.PluginSettings{RemoteRetries: &zero, RemoteRetryBackoff: "2s", RemoteRetryOn: []string{" HTTP 409 ", ""}})
+	if err != nil || policy.retries != 0 || policy.backoff != 2*time.Second || len(policy.retryOn) != 1 || policy.retryOn[0] != "HTTP 409" {
+		t.Errorf("expected the configured policy, got %+v, %v", policy, err)
+	}
+	for _, config := range []models.PluginSettings{
+		{RemoteRetries: &negative},
+		{RemoteRetryBackoff: "soon"},
+		{RemoteRetryBackoff: "-1s"},
+	} {
+		var configErr *ConfigError
+		if _, err := remoteRetryPolicy(&config); !errors.As(err, &configErr) {
+			t.Errorf("expected a ConfigError for %+v, got %v", config, err)
+		}
+	}
+}
+
+func TestQueryRetriesRemoteReadErrors(t *testing.T) {
+	// A missing file fails with an IO error, like an unreachable remote one.
+	// The file shows up while the query waits for its retry.
+	path := filepath.Join(t.TempDir(), "data.csv")
+	ds := newTestDatasource(t, `{"path": "", "remoteRetryOn": ["No files found"], "remoteRetryBackoff": "200ms"}`)
+	logs := recordLogs(t)
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			for _, entry := range logs.messages() {
+				if entry.msg == "Remote read failed, retrying" {
+					_ = os.WriteFile(path, []byte("n\n42\n"), 0o600)
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	res := runQuery(t, ds, fmt.Sprintf("SELECT n FROM read_csv(%s)", quoteLiteral(path)))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "n", data.FieldTypeNullableInt64, []any{int64(42)})
+	if !strings.Contains(fmt.Sprint(res.Frames[0].Meta.Notices), "Query succeeded after 1 retry,") {
+		t.Errorf("expected the retry to be reported, got %+v", res.Frames[0].Meta.Notices)
+	}
+
+	// Other errors fail without retrying.
+	res = runQuery(t, ds, "SELECT * FROM missing_table")
+	if res.Error == nil {
+		t.Fatal("expected the query to fail")
+	}
+	for _, entry := range logs.messages() {
+		if entry.msg == "Remote read failed, retrying" && strings.Contains(fmt.Sprint(entry.args...), "missing_table") {
+			t.Errorf("expected the catalog error not to be retried, got %+v", entry)
+		}
+	}
+}
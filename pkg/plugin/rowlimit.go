@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// rowLimit caps the number of rows read from the results of a query. sqlds
+// scans all rows into a frame, so the cap is applied by the connections, which
+// stop returning rows once it is reached.
+type rowLimit struct {
+	max       int64
+	truncated atomic.Bool
+}
+
+type rowLimitKey struct{}
+
+// withRowLimit returns a context whose queries return at most max rows.
+func withRowLimit(ctx context.Context, max int64) (context.Context, *rowLimit) {
+	limit := &rowLimit{max: max}
+	return context.WithValue(ctx, rowLimitKey{}, limit), limit
+}
+
+type limitedRows struct {
+	typedRows
+	limit *rowLimit
+	read  int64
+}
+
+// Next stops after limit.max rows. It reads one more row to tell a result
+// that was cut short from one that has exactly limit.max rows.
+func (r *limitedRows) Next(dest []driver.Value) error {
+	if r.read >= r.limit.max {
+		if err := r.typedRows.Next(dest); err == nil {
+			r.limit.truncated.Store(true)
+		}
+		return io.EOF
+	}
+	if err := r.typedRows.Next(dest); err != nil {
+		return err
+	}
+	r.read++
+	return nil
+}
+
+// markTruncated adds a warning to the frames of a query that hit the row limit.
+func markTruncated(res backend.DataResponse, max int64) backend.DataResponse {
+	for _, frame := range res.Frames {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Results have been limited to %d rows because the maxRows limit was reached", max),
+		})
+	}
+	return res
+}
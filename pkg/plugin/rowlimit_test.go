@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestMaxRows(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "maxRows": 5}`)
+
+	tests := []struct {
+		name      string
+		rawSQL    string
+		rows      int
+		truncated bool
+	}{
+		{"beyond the cap", "SELECT * FROM range(100)", 5, true},
+		{"exactly the cap", "SELECT * FROM range(5)", 5, false},
+		{"below the cap", "SELECT * FROM range(3)", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runQuery(t, ds, tt.rawSQL)
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			frame := res.Frames[0]
+			if rows, _ := frame.RowLen(); rows != tt.rows {
+				t.Errorf("expected %d rows, got %d", tt.rows, rows)
+			}
+			var notices []data.Notice
+			if frame.Meta != nil {
+				notices = frame.Meta.Notices
+			}
+			if truncated := len(notices) > 0; truncated != tt.truncated {
+				t.Fatalf("expected truncated=%v, got notices %v", tt.truncated, notices)
+			}
+			if tt.truncated {
+				if notices[0].Severity != data.NoticeSeverityWarning || !strings.Contains(notices[0].Text, "limited to 5 rows") {
+					t.Errorf("unexpected notice %+v", notices[0])
+				}
+			}
+		})
+	}
+}
+
+func TestMaxRowsUnset(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	res := runQuery(t, ds, "SELECT * FROM range(2000)")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if rows, _ := res.Frames[0].RowLen(); rows != 2000 {
+		t.Errorf("expected 2000 rows, got %d", rows)
+	}
+	if meta := res.Frames[0].Meta; meta != nil && len(meta.Notices) > 0 {
+		t.Errorf("expected no notices, got %v", meta.Notices)
+	}
+}
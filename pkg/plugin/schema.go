@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// resultColumn is a column of a query result as reported by the driver.
+type resultColumn struct {
+	name     string
+	typeName string
+	scanType reflect.Type
+}
+
+// resultSchema keeps the columns of the last result of a query. sqlds drops
+// all fields of an empty time series result, the columns are recorded by the
+// connections so the fields can be put back.
+type resultSchema struct {
+	mu      sync.Mutex
+	columns []resultColumn
+}
+
+type resultSchemaKey struct{}
+
+// withResultSchema returns a context whose query records its result columns.
+func withResultSchema(ctx context.Context) (context.Context, *resultSchema) {
+	schema := &resultSchema{}
+	return context.WithValue(ctx, resultSchemaKey{}, schema), schema
+}
+
+func (s *resultSchema) record(rows typedRows) {
+	names := rows.Columns()
+	columns := make([]resultColumn, len(names))
+	for i, name := range names {
+		columns[i] = resultColumn{name: name, typeName: rows.ColumnTypeDatabaseTypeName(i), scanType: rows.ColumnTypeScanType(i)}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.columns = columns
+}
+
+// emptyFrame returns a frame without rows whose fields have the types the
+// converters give the columns, the same fields a table result would have.
+func (s *resultSchema) emptyFrame(converters []sqlutil.Converter) *data.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.columns == nil {
+		return nil
+	}
+	fields := make(data.Fields, len(s.columns))
+	for i, column := range s.columns {
+		converter, ok := matchConverter(converters, column)
+		if !ok {
+			if column.scanType == nil {
+				return nil
+			}
+			converter = sqlutil.NewDefaultConverter(column.name, true, column.scanType)
+		}
+		fields[i] = data.NewFieldFromFieldType(converter.FrameConverter.FieldType, 0)
+		fields[i].Name = column.name
+	}
+	return data.NewFrame("", fields...)
+}
+
+// matchConverter picks the converter for a column like sqlutil does.
+func matchConverter(converters []sqlutil.Converter, column resultColumn) (sqlutil.Converter, bool) {
+	for _, c := range converters {
+		if (c.InputColumnName != "" && c.InputColumnName == column.name) ||
+			c.InputTypeName == column.typeName ||
+			(c.InputTypeRegex != nil && c.InputTypeRegex.MatchString(column.typeName)) {
+			return c, true
+		}
+	}
+	return sqlutil.Converter{}, false
+}
+
+// withEmptyFrame gives an empty result without fields the fields of its
+// columns, so panels and transformations still see the schema of the query.
+func withEmptyFrame(res backend.DataResponse, schema *resultSchema, converters []sqlutil.Converter) backend.DataResponse {
+	if res.Error != nil || len(res.Frames) != 1 || len(res.Frames[0].Fields) > 0 {
+		return res
+	}
+	frame := schema.emptyFrame(converters)
+	if frame == nil {
+		return res
+	}
+	frame.Name = res.Frames[0].Name
+	frame.Meta = res.Frames[0].Meta
+	res.Frames[0] = frame
+	return res
+}
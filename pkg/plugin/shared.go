@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	duckdb "github.com/duckdb/duckdb-go/v2"
+)
+
+// connectorCache shares the connectors of local database files between the
+// datasource instances of the process. duckdb-go opens files through DuckDB's
+// instance cache, so all connectors of a file already share one database, but
+// DuckDB refuses to open a file with another configuration, like the other
+// access mode, while it is open. The cache tracks the instances using a file,
+// so a conflicting mode is reported with the data sources involved, a
+// replacement instance can wait for the one it replaces, and instances set up
+// the same way share a connector that boots once.
+type connectorCache struct {
+	mu      sync.Mutex
+	entries map[string]*sharedFile
+}
+
+// sharedFile is a database file open in the process.
+type sharedFile struct {
+	readOnly bool
+	// connectors holds a connector per boot fingerprint. The boot queries run
+	// once per connector, so instances set up differently do not share one.
+	connectors map[string]*sharedConnector
+	refs       int
+	// holders counts the references of each datasource UID.
+	holders map[string]int
+	// closed is closed once the last reference is released.
+	closed chan struct{}
+}
+
+type sharedConnector struct {
+	connector *duckdb.Connector
+	refs      int
+}
+
+var sharedConnectors = &connectorCache{entries: make(map[string]*sharedFile)}
+
+// replaceWait is how long an instance waits for the instances of the same
+// datasource it replaces to release a file open in the other mode. The SDK
+// disposes replaced instances 5 seconds after creating their replacement.
+var replaceWait = 10 * time.Second
+
+// normalizeDBPath returns the key of a database file, so different spellings
+// of the same path share a connector.
+func normalizeDBPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return filepath.Clean(path)
+}
+
+// bootFingerprint hashes the statements that set up the connections.
+func bootFingerprint(queries ...[]string) string {
+	h := sha256.New()
+	for _, list := range queries {
+		for _, query := range list {
+			h.Write([]byte(query))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// acquire returns the connector of the database file at path for the
+// datasource uid, calling open when no instance set up with the same
+// fingerprint has it open yet. DuckDB cannot open a file in read-only mode
+// while it is open in read-write mode, or the other way round. When only
+// replaced instances of the same datasource hold the file, for example after
+// its read-only setting was changed, acquire waits for them to be disposed,
+// at most replaceWait and only as long as ctx is not done. Otherwise it fails,
+// and the next request tries again. The returned release function must be
+// called instead of closing the connector.
+func (c *connectorCache) acquire(ctx context.Context, uid, path string, readOnly bool, fingerprint string, open func() (*duckdb.Connector, error)) (*duckdb.Connector, func() error, error) {
+	key := normalizeDBPath(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var deadline <-chan time.Time
+	file, ok := c.entries[key]
+	for ok && file.readOnly != readOnly {
+		if uid == "" || file.holders[uid] != file.refs {
+			return nil, nil, fmt.Errorf("database file %s is already open in %s mode by another data source, all data sources of a file must use the same read-only setting", path, accessMode(file.readOnly))
+		}
+		if deadline == nil {
+			deadline = time.After(replaceWait)
+		}
+		c.mu.Unlock()
+		select {
+		case <-file.closed:
+			c.mu.Lock()
+		case <-deadline:
+			c.mu.Lock()
+			return nil, nil, fmt.Errorf("database file %s is still open in %s mode by the instance this data source replaces", path, accessMode(file.readOnly))
+		case <-ctx.Done():
+			c.mu.Lock()
+			return nil, nil, ctx.Err()
+		}
+		file, ok = c.entries[key]
+	}
+	if !ok {
+		file = &sharedFile{readOnly: readOnly, connectors: map[string]*sharedConnector{}, holders: map[string]int{}, closed: make(chan struct{})}
+	}
+	shared, ok := file.connectors[fingerprint]
+	if !ok {
+		connector, err := open()
+		if err != nil {
+			return nil, nil, err
+		}
+		shared = &sharedConnector{connector: connector}
+		file.connectors[fingerprint] = shared
+		c.entries[key] = file
+	}
+	shared.refs++
+	file.refs++
+	file.holders[uid]++
+
+	released := false
+	release := func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if released {
+			return nil
+		}
+		released = true
+		file.holders[uid]--
+		file.refs--
+		shared.refs--
+		var err error
+		if shared.refs == 0 {
+			delete(file.connectors, fingerprint)
+			err = shared.connector.Close()
+		}
+		if file.refs == 0 {
+			delete(c.entries, key)
+			close(file.closed)
+		}
+		return err
+	}
+	return shared.connector, release, nil
+}
+
+// refs returns the number of instances using the connector of path.
+func (c *connectorCache) refs(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if file, ok := c.entries[normalizeDBPath(path)]; ok {
+		return file.refs
+	}
+	return 0
+}
+
+func accessMode(readOnly bool) string {
+	if readOnly {
+		return "read-only"
+	}
+	return "read-write"
+}
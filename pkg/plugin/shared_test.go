@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func connectPath(t *testing.T, jsonData string) error {
+	t.Helper()
+	db, err := (&DuckDBDriver{}).Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)}, nil)
+	if err == nil {
+		t.Cleanup(func() { db.Close() })
+	}
+	return err
+}
+
+func TestSharedConnector(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+	settings := fmt.Sprintf(`{"path": %q, "initSql": "CREATE TABLE t2 AS SELECT 2 AS y"}`, path)
+
+	first, err := (&DuckDBDriver{}).Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(settings)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	// The same file through another spelling of its path.
+	other := fmt.Sprintf(`{"path": %q, "initSql": "CREATE TABLE t2 AS SELECT 2 AS y"}`, filepath.Join(filepath.Dir(path), ".", filepath.Base(path)))
+	second, err := (&DuckDBDriver{}).Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(other)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if n := sharedConnectors.refs(path); n != 2 {
+		t.Fatalf("expected both instances to share the connector, got %d references", n)
+	}
+
+	// The Init SQL ran once, creating the table again would have failed.
+	var y int
+	if err := second.QueryRow("SELECT y FROM t2").Scan(&y); err != nil || y != 2 {
+		t.Fatalf("expected to read the table created by the first instance, got %d, %v", y, err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := sharedConnectors.refs(path); n != 1 {
+		t.Errorf("expected 1 reference after closing the first instance, got %d", n)
+	}
+	var x int
+	if err := second.QueryRow("SELECT x FROM t").Scan(&x); err != nil || x != 1 {
+		t.Fatalf("expected the second instance to keep working, got %d, %v", x, err)
+	}
+}
+
+func connectDatasource(uid, jsonData string) (*sql.DB, error) {
+	return (&DuckDBDriver{}).Connect(context.Background(), backend.DataSourceInstanceSettings{UID: uid, JSONData: []byte(jsonData)}, nil)
+}
+
+func TestSharedConnectorConflictingModes(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+	readOnly := fmt.Sprintf(`{"path": %q, "readOnly": true}`, path)
+	readWrite := fmt.Sprintf(`{"path": %q}`, path)
+
+	first, err := connectDatasource("a", readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := connectDatasource("b", readOnly)
+	if err != nil {
+		t.Fatalf("expected a second read-only instance to share the connector, got %v", err)
+	}
+	if n := sharedConnectors.refs(path); n != 2 {
+		t.Errorf("expected 2 references, got %d", n)
+	}
+
+	// The conflict is not a config error, so the next request tries again.
+	_, err = connectDatasource("c", readWrite)
+	var configErr *ConfigError
+	if err == nil || errors.As(err, &configErr) || !strings.Contains(err.Error(), "already open in read-only mode") {
+		t.Fatalf("expected a mode conflict that is not a config error, got %v", err)
+	}
+
+	first.Close()
+	second.Close()
+	db, err := connectDatasource("c", readWrite)
+	if err != nil {
+		t.Fatalf("expected the file to open read-write once the read-only instances are gone, got %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO t VALUES (2)"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedConnectorReplacement(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+	settings := func(readOnly bool) backend.DataSourceInstanceSettings {
+		return backend.DataSourceInstanceSettings{UID: "ds", JSONData: []byte(fmt.Sprintf(`{"path": %q, "readOnly": %t}`, path, readOnly))}
+	}
+
+	old := NewDatasource(&DuckDBDriver{})
+	if _, err := old.NewDatasource(context.Background(), settings(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The SDK creates the replacement of an instance whose settings changed
+	// first and disposes the old instance a few seconds later.
+	time.AfterFunc(100*time.Millisecond, old.Dispose)
+	replacement := NewDatasource(&DuckDBDriver{})
+	if _, err := replacement.NewDatasource(context.Background(), settings(false)); err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Dispose()
+	if replacement.configErr != nil {
+		t.Fatalf("expected the replacement to take over the file, got %v", replacement.configErr)
+	}
+	res := runQuery(t, replacement, "INSERT INTO t VALUES (2) RETURNING x")
+	if res.Error != nil {
+		t.Fatalf("expected the replacement to open the file read-write, got %v", res.Error)
+	}
+	if n := sharedConnectors.refs(path); n != 1 {
+		t.Errorf("expected 1 reference, got %d", n)
+	}
+}
+
+func TestSharedConnectorReplacementTimeout(t *testing.T) {
+	defer func(wait time.Duration) { replaceWait = wait }(replaceWait)
+	replaceWait = 50 * time.Millisecond
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+
+	old, err := connectDatasource("ds", fmt.Sprintf(`{"path": %q}`, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	_, err = connectDatasource("ds", fmt.Sprintf(`{"path": %q, "readOnly": true}`, path))
+	if err == nil || !strings.Contains(err.Error(), "still open in read-write mode") {
+		t.Fatalf("expected the replacement to give up waiting, got %v", err)
+	}
+
+	// Waiting stops with the request that created the instance.
+	replaceWait = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	settings := backend.DataSourceInstanceSettings{UID: "ds", JSONData: []byte(fmt.Sprintf(`{"path": %q, "readOnly": true}`, path))}
+	if _, err := (&DuckDBDriver{}).Connect(ctx, settings, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestSharedConnectorsBootSeparately(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+
+	first, err := connectDatasource("a", fmt.Sprintf(`{"path": %q, "initSql": "CREATE TEMP TABLE a AS SELECT 1 AS v"}`, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := first.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	// Different Init SQL opens a connector of its own, booted by its own
+	// settings.
+	second, err := connectDatasource("b", fmt.Sprintf(`{"path": %q, "initSql": "CREATE TEMP TABLE b AS SELECT 2 AS v"}`, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	var v int
+	if err := second.QueryRow("SELECT v FROM b").Scan(&v); err != nil || v != 2 {
+		t.Fatalf("expected the second connector to run its own Init SQL, got %d, %v", v, err)
+	}
+	if n := sharedConnectors.refs(path); n != 2 {
+		t.Errorf("expected both connectors to hold the file, got %d references", n)
+	}
+
+	// The file stays open in read-write mode while the second connector holds
+	// it.
+	first.Close()
+	if _, err := connectDatasource("c", fmt.Sprintf(`{"path": %q, "readOnly": true}`, path)); err == nil || !strings.Contains(err.Error(), "already open in read-write mode") {
+		t.Fatalf("expected a mode conflict with the second connector, got %v", err)
+	}
+}
+
+func TestSharedConnectorReload(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t AS SELECT 1 AS x")
+	ds := newTestDatasource(t, fmt.Sprintf(`{"path": %q}`, path))
+	defer ds.Dispose()
+	if res := runQuery(t, ds, "SELECT x FROM t"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	before := map[string]*sharedConnector{}
+	for fingerprint, shared := range sharedConnectors.entries[normalizeDBPath(path)].connectors {
+		before[fingerprint] = shared
+	}
+
+	// A modified file reopens the database instead of taking another
+	// reference to the open one.
+	ds.fileWatcher.lastModified = time.Time{}
+	if res := runQuery(t, ds, "SELECT x FROM t"); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if n := sharedConnectors.refs(path); n != 1 {
+		t.Fatalf("expected the reload to keep 1 reference, got %d", n)
+	}
+	file := sharedConnectors.entries[normalizeDBPath(path)]
+	for fingerprint, shared := range file.connectors {
+		if before[fingerprint] == shared {
+			t.Error("expected the reload to open a new connector")
+		}
+	}
+}
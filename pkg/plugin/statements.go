@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// splitStatements splits a SQL script on top level semicolons. Semicolons inside
+// string literals, quoted identifiers, dollar quoted strings and comments do not
+// end a statement. Empty statements are dropped and the returned statements do
+// not include the trailing semicolon.
+func splitStatements(script string) []string {
+	statements := []string{}
+	var current strings.Builder
+
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		current.Reset()
+		if stmt != "" && !isOnlyComments(stmt) {
+			statements = append(statements, stmt)
+		}
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := skipQuoted(script, i, c)
+			current.WriteString(script[i:end])
+			i = end - 1
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			current.WriteString(script[i : i+end])
+			i += end - 1
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = len(script)
+			} else {
+				end = i + 2 + end + 2
+			}
+			current.WriteString(script[i:end])
+			i = end - 1
+		case c == '$':
+			tag, ok := dollarQuoteTag(script[i:])
+			if !ok {
+				current.WriteByte(c)
+				continue
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				end = len(script)
+			} else {
+				end = i + len(tag) + end + len(tag)
+			}
+			current.WriteString(script[i:end])
+			i = end - 1
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// skipQuoted returns the index just past the closing quote of the literal
+// starting at start. A doubled quote is an escaped quote.
+func skipQuoted(script string, start int, quote byte) int {
+	for i := start + 1; i < len(script); i++ {
+		if script[i] != quote {
+			continue
+		}
+		if i+1 < len(script) && script[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(script)
+}
+
+// dollarQuoteTag returns the opening tag ($$ or $tag$) if s starts with one.
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isOnlyComments reports whether stmt contains nothing but comments and whitespace.
+func isOnlyComments(stmt string) bool {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case stmt == "":
+			return true
+		case strings.HasPrefix(stmt, "--"):
+			end := strings.IndexByte(stmt, '\n')
+			if end < 0 {
+				return true
+			}
+			stmt = stmt[end+1:]
+		case strings.HasPrefix(stmt, "/*"):
+			end := strings.Index(stmt, "*/")
+			if end < 0 {
+				return true
+			}
+			stmt = stmt[end+2:]
+		default:
+			return false
+		}
+	}
+}
+
+// checkSingleStatementModel returns a ConfigError for a query model whose
+// rawSql holds more than one statement, which only run with the
+// multiStatements setting. Models that fail to parse are left for sqlds to
+// report.
+func checkSingleStatementModel(query backend.DataQuery) error {
+	var model struct {
+		RawSQL string `json:"rawSql"`
+	}
+	if err := json.Unmarshal(query.JSON, &model); err != nil {
+		return nil
+	}
+	if n := len(splitStatements(model.RawSQL)); n > 1 {
+		return &ConfigError{fmt.Sprintf("The query has %d statements, only a single statement can run unless the multiStatements setting is enabled", n)}
+	}
+	return nil
+}
+
+// scriptRun remembers whether a script failed in the context of a query.
+// sqlds retries failed queries on a new connection, which would run the setup
+// statements of the script, like an INSERT, a second time. Instead the retry
+// fails with the error of the first run.
+type scriptRun struct {
+	mu     sync.Mutex
+	failed error
+}
+
+type scriptRunKey struct{}
+
+// withScriptRun returns a context in which a failed script runs only once.
+func withScriptRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scriptRunKey{}, &scriptRun{})
+}
+
+// run calls script unless an earlier call failed, then it returns that error.
+func (r *scriptRun) run(script func() (driver.Rows, error)) (driver.Rows, error) {
+	r.mu.Lock()
+	failed := r.failed
+	r.mu.Unlock()
+	if failed != nil {
+		return nil, failed
+	}
+	rows, err := script()
+	if err != nil {
+		r.mu.Lock()
+		r.failed = err
+		r.mu.Unlock()
+	}
+	return rows, err
+}
+
+// onlySettings reports whether statements only change settings, like the
+// SET TimeZone statement added for the dashboard timezone, so running them
+// again is harmless.
+func onlySettings(statements []string) bool {
+	for _, stmt := range statements {
+		if keyword := statementKeyword(stmt); keyword != "SET" && keyword != "RESET" {
+			return false
+		}
+	}
+	return true
+}
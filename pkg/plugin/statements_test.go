@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{"empty", "  ", []string{}},
+		{"single without semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"multiple", "SELECT 1; SELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"semicolon in string", "SELECT 'a;b'; SELECT 'it''s;'", []string{"SELECT 'a;b'", "SELECT 'it''s;'"}},
+		{"semicolon in identifier", `SELECT 1 AS "a;b"; SELECT 2`, []string{`SELECT 1 AS "a;b"`, "SELECT 2"}},
+		{"semicolon in comments", "SELECT 1 -- one; two\n; /* ; */ SELECT 2", []string{"SELECT 1 -- one; two", "/* ; */ SELECT 2"}},
+		{"dollar quoted", "SELECT $$a;b$$; SELECT $x$c;d$x$", []string{"SELECT $$a;b$$", "SELECT $x$c;d$x$"}},
+		{"comment only statements are dropped", "SELECT 1; -- trailing comment", []string{"SELECT 1"}},
+		{"positional parameter is not a dollar quote", "SELECT $1; SELECT 2", []string{"SELECT $1", "SELECT 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.script)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMultiStatementsDisabled(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	for _, rawSQL := range []string{
+		"SELECT 'a;b' AS s;",
+		"-- setup; none\nSELECT 1 AS s; -- done",
+		`SELECT 1 AS "s;"`,
+	} {
+		if res := runQuery(t, ds, rawSQL); res.Error != nil {
+			t.Errorf("%q: expected a single statement to run, got %v", rawSQL, res.Error)
+		}
+	}
+
+	res := runQuery(t, ds, "CREATE TEMP TABLE t AS SELECT 1 AS x; SELECT * FROM t")
+	if res.Error == nil || res.Status != backend.StatusBadRequest || !strings.Contains(res.Error.Error(), "multiStatements") {
+		t.Fatalf("expected the script to be rejected, got %v %v", res.Status, res.Error)
+	}
+	if res := runQuery(t, ds, "SELECT * FROM t"); res.Error == nil {
+		t.Errorf("expected the rejected script not to run")
+	}
+}
+
+func TestMultiStatements(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "multiStatements": true, "useQueryTimezone": true}`)
+
+	// Setup statements run on the connection of the final query.
+	res := runQuery(t, ds, "CREATE TEMP TABLE t AS SELECT range AS x FROM range(3);\nINSERT INTO t VALUES (10);\nSELECT sum(x)::BIGINT AS total FROM t;")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "total", data.FieldTypeNullableInt64, []any{int64(13)})
+
+	// Semicolons in literals, identifiers and comments don't split statements.
+	res = runQuery(t, ds, `CREATE TEMP TABLE s AS SELECT 'a;b' AS "v;w"; -- not; a statement
+SELECT "v;w" || ';' AS v FROM s /* ; */`)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "v", data.FieldTypeNullableString, []any{"a;b;"})
+
+	// Parameters are bound to the final statement.
+	model, err := json.Marshal(map[string]any{
+		"rawSql": "CREATE TEMP TABLE p AS SELECT range AS x FROM range(5); SELECT count(*) AS n FROM p WHERE x < ?",
+		"format": 1,
+		"params": []any{2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = runDataQuery(t, ds, backend.DataQuery{JSON: model})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "n", data.FieldTypeNullableInt64, []any{int64(2)})
+
+	// A failing setup statement fails the query.
+	res = runQuery(t, ds, "INSERT INTO missing_table VALUES (1); SELECT 1 AS x")
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "missing_table") {
+		t.Errorf("expected the setup error, got %v", res.Error)
+	}
+}
+
+func TestCheckSingleStatementModel(t *testing.T) {
+	for rawSQL, statements := range map[string]int{
+		"SELECT 1":                      0,
+		"SELECT ';'; ":                  0,
+		"SELECT 1; SELECT 2":            2,
+		"SET x = 1; SELECT 1; SELECT 2": 3,
+	} {
+		model, _ := json.Marshal(map[string]any{"rawSql": rawSQL})
+		err := checkSingleStatementModel(backend.DataQuery{JSON: model})
+		if statements == 0 {
+			if err != nil {
+				t.Errorf("%q: expected no error, got %v", rawSQL, err)
+			}
+			continue
+		}
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || !strings.Contains(err.Error(), fmt.Sprintf("has %d statements", statements)) {
+			t.Errorf("%q: expected a ConfigError for %d statements, got %v", rawSQL, statements, err)
+		}
+	}
+}
+
+func TestMultiStatementsRetries(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t (x INTEGER); CREATE SEQUENCE attempts")
+	ds := newTestDatasource(t, fmt.Sprintf(`{"path": %q, "multiStatements": true, "retryOn": ["transient failure"], "retries": 2, "pause": 0}`, path))
+	count := func() int64 {
+		t.Helper()
+		res := runQuery(t, ds, "SELECT count(*) AS n FROM t")
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		return *res.Frames[0].Fields[0].At(0).(*int64)
+	}
+
+	// A failed script is not run again, the INSERT happens once.
+	res := runQuery(t, ds, "INSERT INTO t VALUES (1); SELECT error('transient failure') AS x")
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "transient failure") {
+		t.Fatalf("expected the script to fail, got %v", res.Error)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected the setup statement to run once, got %d rows", n)
+	}
+
+	// Scripts that only change settings before the query are retried.
+	res = runQuery(t, ds, "SET VARIABLE v = 42; SELECT CASE WHEN nextval('attempts') = 1 THEN error('transient failure') ELSE getvariable('v') END AS v")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "v", data.FieldTypeNullableInt32, []any{int32(42)})
+}
+
+func TestMultiStatementsRemoteRetries(t *testing.T) {
+	// Only the final statement is retried on the connection, creating the
+	// temporary table again would fail.
+	path := filepath.Join(t.TempDir(), "data.csv")
+	ds := newTestDatasource(t, `{"path": "", "multiStatements": true, "remoteRetryOn": ["No files found"], "remoteRetryBackoff": "200ms"}`)
+	logs := recordLogs(t)
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			for _, entry := range logs.messages() {
+				if entry.msg == "Remote read failed, retrying" {
+					_ = os.WriteFile(path, []byte("n\n42\n"), 0o600)
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	res := runQuery(t, ds, fmt.Sprintf("CREATE TEMP TABLE runs AS SELECT 1 AS r; SELECT n + r AS n FROM read_csv(%s), runs", quoteLiteral(path)))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	assertField(t, res.Frames[0], "n", data.FieldTypeNullableInt64, []any{int64(43)})
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	// Grafana images do not always ship the timezone database.
+	_ "time/tzdata"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// withQueryTimezone applies the dashboard timezone sent in the timezone field
+// of the query models. The time range is moved to the timezone, so the macros
+// emit wall clock times of the dashboard, and the query is prefixed with a
+// SET TimeZone statement, so DuckDB's own time functions use it too. Queries
+// without a known timezone reset the timezone of the connection they run on.
+// Instants are not changed, the converters keep returning UTC times.
+func withQueryTimezone(req *backend.QueryDataRequest) (*backend.QueryDataRequest, error) {
+	queries := make([]backend.DataQuery, len(req.Queries))
+	for i, query := range req.Queries {
+		var model map[string]any
+		if err := json.Unmarshal(query.JSON, &model); err != nil {
+			return nil, err
+		}
+		rawSQL, ok := model["rawSql"].(string)
+		if !ok || strings.TrimSpace(rawSQL) == "" {
+			queries[i] = query
+			continue
+		}
+
+		name, _ := model["timezone"].(string)
+		if loc, ok := queryLocation(name); ok {
+			query.TimeRange.From = query.TimeRange.From.In(loc)
+			query.TimeRange.To = query.TimeRange.To.In(loc)
+			model["rawSql"] = "SET TimeZone = " + quoteLiteral(loc.String()) + ";\n" + rawSQL
+		} else {
+			model["rawSql"] = "RESET TimeZone;\n" + rawSQL
+		}
+		raw, err := json.Marshal(model)
+		if err != nil {
+			return nil, err
+		}
+		query.JSON = raw
+		queries[i] = query
+	}
+
+	mutated := *req
+	mutated.Queries = queries
+	return &mutated, nil
+}
+
+// queryLocation resolves the timezone of a query. Grafana sends IANA names,
+// "utc", or "browser" when the browser did not resolve its own timezone.
+func queryLocation(name string) (*time.Location, bool) {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "", "browser":
+		return nil, false
+	case "utc":
+		return time.UTC, true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || loc == time.Local {
+		backend.Logger.Debug("Ignoring unknown query timezone", "timezone", name, "error", err)
+		return nil, false
+	}
+	return loc, true
+}
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func timezoneQuery(t *testing.T, ds *SQLDataSourceWrapper, timezone string) backend.DataResponse {
+	t.Helper()
+	model, err := json.Marshal(map[string]any{
+		"rawSql":   "SELECT current_setting('TimeZone') AS tz, $__timeFrom() AS time_from",
+		"format":   1,
+		"timezone": timezone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Local()
+	res := runDataQuery(t, ds, backend.DataQuery{
+		JSON:      model,
+		TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	return res
+}
+
+func TestQueryTimezone(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "useQueryTimezone": true, "maxOpenConns": 1}`)
+
+	res := timezoneQuery(t, ds, "America/New_York")
+	if tz := *res.Frames[0].Fields[0].At(0).(*string); tz != "America/New_York" {
+		t.Errorf("expected the session timezone to be set from the query, got %s", tz)
+	}
+	if from := *res.Frames[0].Fields[1].At(0).(*string); from != "2024-01-01T07:00:00-05:00" {
+		t.Errorf("expected the time range in the query timezone, got %s", from)
+	}
+
+	// The next query on the same connection does not inherit the timezone.
+	res = timezoneQuery(t, ds, "")
+	if tz := *res.Frames[0].Fields[0].At(0).(*string); tz == "America/New_York" {
+		t.Errorf("expected the session timezone to be reset")
+	}
+	if from := *res.Frames[0].Fields[1].At(0).(*string); from != "2024-01-01T12:00:00Z" {
+		t.Errorf("expected the time range in UTC, got %s", from)
+	}
+}
+
+func TestQueryTimezoneDisabled(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": ""}`)
+
+	res := timezoneQuery(t, ds, "America/New_York")
+	if from := *res.Frames[0].Fields[1].At(0).(*string); from != "2024-01-01T12:00:00Z" {
+		t.Errorf("expected the time range in UTC, got %s", from)
+	}
+}
+
+func TestQueryLocation(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Europe/Berlin": "Europe/Berlin",
+		"utc":           "UTC",
+		"browser":       "",
+		"":              "",
+		"Not/AZone":     "",
+		"Local":         "",
+	} {
+		got := ""
+		if loc, ok := queryLocation(name); ok {
+			got = loc.String()
+		}
+		if got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, got)
+		}
+	}
+}
+
+func TestQueryTimezoneRoundedMacros(t *testing.T) {
+	ds := newTestDatasource(t, `{"path": "", "useQueryTimezone": true}`)
+	model, err := json.Marshal(map[string]any{
+		"rawSql":   "SELECT $__timeFromRounded(1d) AS time_from, $__timeToRounded(1d) AS time_to",
+		"format":   1,
+		"timezone": "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 23:30 UTC is already the next day in Berlin.
+	from := time.Date(2024, 1, 1, 23, 30, 0, 0, time.UTC)
+	res := runDataQuery(t, ds, backend.DataQuery{
+		JSON:      model,
+		TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+	})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if got := *res.Frames[0].Fields[0].At(0).(*string); got != "2024-01-02T00:00:00+01:00" {
+		t.Errorf("expected the start rounded to midnight in Berlin, got %s", got)
+	}
+	if got := *res.Frames[0].Fields[1].At(0).(*string); got != "2024-01-03T00:00:00+01:00" {
+		t.Errorf("expected the end rounded to midnight in Berlin, got %s", got)
+	}
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+// MotherDuck token sources. The literal token is the motherDuckToken secure
+// setting, the others name the environment variable or file it is read from.
+const (
+	tokenSourceLiteral = "literal"
+	tokenSourceEnv     = "env"
+	tokenSourceFile    = "file"
+)
+
+// resolveMotherDuckToken replaces the MotherDuck token of config with the one
+// read from its token source. It is resolved on every connect, so a rotated
+// token file is picked up by the next reconnect. The token itself never ends
+// up in an error.
+func resolveMotherDuckToken(config *models.PluginSettings) error {
+	source := strings.ToLower(strings.TrimSpace(config.MotherDuckTokenSource))
+	ref := strings.TrimSpace(config.MotherDuckTokenRef)
+
+	var token string
+	switch source {
+	case "", tokenSourceLiteral:
+		return nil
+	case tokenSourceEnv:
+		if ref == "" {
+			return &ConfigError{"MotherDuck token source env needs the name of the environment variable in motherDuckTokenRef"}
+		}
+		token = strings.TrimSpace(os.Getenv(ref))
+		if token == "" {
+			return &ConfigError{fmt.Sprintf("MotherDuck token environment variable %s is not set or empty", ref)}
+		}
+	case tokenSourceFile:
+		if ref == "" {
+			return &ConfigError{"MotherDuck token source file needs the path of the file in motherDuckTokenRef"}
+		}
+		content, err := os.ReadFile(ref)
+		if err != nil {
+			return &ConfigError{fmt.Sprintf("Cannot read the MotherDuck token file %s: %v", ref, err)}
+		}
+		// Mounted secrets usually end with a newline.
+		token = strings.TrimSpace(string(content))
+		if token == "" {
+			return &ConfigError{fmt.Sprintf("MotherDuck token file %s is empty", ref)}
+		}
+	default:
+		return &ConfigError{"Invalid MotherDuck token source: " + config.MotherDuckTokenSource + " -> must be one of literal, env or file"}
+	}
+
+	if config.Secrets == nil {
+		config.Secrets = &models.SecretPluginSettings{}
+	}
+	config.Secrets.MotherDuckToken = token
+	return nil
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+func TestResolveMotherDuckToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte(" \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GRAFANA_TEST_MD_TOKEN", " env-token ")
+	t.Setenv("GRAFANA_TEST_MD_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		source  string
+		ref     string
+		token   string
+		message string
+	}{
+		{"default", "", "", "secret-token", ""},
+		{"literal", "literal", "ignored", "secret-token", ""},
+		{"env", "env", "GRAFANA_TEST_MD_TOKEN", "env-token", ""},
+		{"env upper case", " ENV ", "GRAFANA_TEST_MD_TOKEN", "env-token", ""},
+		{"empty env", "env", "GRAFANA_TEST_MD_EMPTY", "", "environment variable GRAFANA_TEST_MD_EMPTY is not set or empty"},
+		{"unset env", "env", "GRAFANA_TEST_MD_UNSET", "", "environment variable GRAFANA_TEST_MD_UNSET is not set or empty"},
+		{"env without name", "env", " ", "", "needs the name of the environment variable"},
+		{"file", "file", tokenFile, "file-token", ""},
+		{"empty file", "file", emptyFile, "", "MotherDuck token file " + emptyFile + " is empty"},
+		{"missing file", "file", filepath.Join(dir, "missing"), "", "Cannot read the MotherDuck token file"},
+		{"file without path", "file", "", "", "needs the path of the file"},
+		{"invalid source", "vault", "", "", "Invalid MotherDuck token source: vault"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &models.PluginSettings{
+				MotherDuckTokenSource: tt.source,
+				MotherDuckTokenRef:    tt.ref,
+				Secrets:               &models.SecretPluginSettings{MotherDuckToken: "secret-token"},
+			}
+			err := resolveMotherDuckToken(config)
+			if tt.message == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if config.Secrets.MotherDuckToken != tt.token {
+					t.Errorf("expected token %q, got %q", tt.token, config.Secrets.MotherDuckToken)
+				}
+				return
+			}
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("expected a ConfigError containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+
+	// Without secure settings the resolved token is still set.
+	config := &models.PluginSettings{MotherDuckTokenSource: "file", MotherDuckTokenRef: tokenFile}
+	if err := resolveMotherDuckToken(config); err != nil || config.Secrets.MotherDuckToken != "file-token" {
+		t.Errorf("expected the file token, got %+v, %v", config.Secrets, err)
+	}
+}
+
+func TestConnectResolvesMotherDuckToken(t *testing.T) {
+	// The missing token of an md: path is reported by the token source.
+	driver := &DuckDBDriver{}
+	_, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"path": "md:my_db", "motherDuckTokenSource": "env", "motherDuckTokenRef": "GRAFANA_TEST_MD_UNSET"}`),
+	}, nil)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || !strings.Contains(err.Error(), "GRAFANA_TEST_MD_UNSET") {
+		t.Fatalf("expected a ConfigError naming the environment variable, got %v", err)
+	}
+
+	// The resolved token is used by the boot queries.
+	t.Setenv("GF_PATHS_DATA", "")
+	t.Setenv("GRAFANA_TEST_MD_TOKEN", "env-token")
+	config := &models.PluginSettings{
+		Path:                  "md:my_db",
+		MotherDuckTokenSource: "env",
+		MotherDuckTokenRef:    "GRAFANA_TEST_MD_TOKEN",
+		Secrets:               &models.SecretPluginSettings{},
+	}
+	if err := resolveMotherDuckToken(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	queries, err := bootQueries(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, query := range queries {
+		found = found || query == "SET motherduck_token='env-token';"
+	}
+	if !found {
+		t.Errorf("expected the resolved token to be set, got %q", queries)
+	}
+}
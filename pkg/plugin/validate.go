@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+// ValidateConfig checks the datasource settings before connecting, so that
+// mistakes are reported on the config page instead of on the first query.
+// All problems are returned as a *ConfigError.
+func ValidateConfig(config *models.PluginSettings) error {
+	path := strings.TrimSpace(config.Path)
+
+	if (strings.HasPrefix(path, "'") && strings.HasSuffix(path, "'")) ||
+		(strings.HasPrefix(path, "\"") && strings.HasSuffix(path, "\"")) {
+		return &ConfigError{"Invalid path: " + path + " -> example input: md:sample_data"}
+	}
+
+	mode, err := databaseMode(config)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case modeMotherDuck:
+		if _, err := normalizeMotherDuckPath(path); err != nil {
+			return err
+		}
+		if config.Secrets == nil || config.Secrets.MotherDuckToken == "" {
+			return &ConfigError{"MotherDuck Token is missing for motherduck connection"}
+		}
+		if config.ReadOnly {
+			return &ConfigError{"Read-only mode only applies to local database files, remove it for MotherDuck paths"}
+		}
+	case modeMemory:
+		if config.ReadOnly {
+			return &ConfigError{"Read-only mode needs a database file, an in-memory database cannot be opened read-only"}
+		}
+	default:
+		if err := validateLocalPath(path, config.ReadOnly); err != nil {
+			return err
+		}
+	}
+
+	if config.MaxRows < 0 {
+		return &ConfigError{fmt.Sprintf("Invalid max rows: %d -> must be a positive number", config.MaxRows)}
+	}
+
+	if _, err := cacheTTL(config); err != nil {
+		return err
+	}
+
+	if tempDirectory := strings.TrimSpace(config.TempDirectory); tempDirectory != "" {
+		if err := validateTempDirectory(tempDirectory); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Database modes. The mode names the kind of database a data source opens,
+// so that an empty path is not taken for an in-memory database by mistake.
+const (
+	modeMemory     = "memory"
+	modeFile       = "file"
+	modeMotherDuck = "motherduck"
+)
+
+// databaseMode returns the mode of config. An explicit mode must match the
+// path, without one it is derived from the path.
+func databaseMode(config *models.PluginSettings) (string, error) {
+	path := strings.TrimSpace(config.Path)
+	derived := modeFile
+	switch {
+	case path == "":
+		derived = modeMemory
+	case strings.HasPrefix(path, "md:"):
+		derived = modeMotherDuck
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(config.Mode))
+	switch mode {
+	case "":
+		return derived, nil
+	case modeMemory:
+		if derived != modeMemory {
+			return "", &ConfigError{"Mode memory opens an in-memory database, remove the path " + path + " or change the mode"}
+		}
+	case modeFile:
+		if derived == modeMemory {
+			return "", &ConfigError{"Mode file needs the path of a database file, the path is empty"}
+		}
+		if derived == modeMotherDuck {
+			return "", &ConfigError{"Mode file needs a local path, " + path + " is a MotherDuck path -> use mode motherduck"}
+		}
+	case modeMotherDuck:
+		if derived != modeMotherDuck {
+			return "", &ConfigError{"Mode motherduck needs an md: path -> example input: md:sample_data"}
+		}
+	default:
+		return "", &ConfigError{"Invalid mode: " + config.Mode + " -> must be one of memory, file or motherduck"}
+	}
+	return mode, nil
+}
+
+// validateTempDirectory checks that DuckDB can spill to the temp directory by
+// creating a file in it.
+func validateTempDirectory(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return &ConfigError{fmt.Sprintf("Temp directory %s does not exist or is not a directory", dir)}
+	}
+	f, err := os.CreateTemp(dir, ".grafana-duckdb-*")
+	if err != nil {
+		return &ConfigError{fmt.Sprintf("Temp directory %s is not writable: %v", dir, err)}
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// databaseFileExtensions are the extensions DuckDB database files usually have.
+var databaseFileExtensions = map[string]bool{".duckdb": true, ".db": true, ".ddb": true}
+
+// duckdbMagic follows the checksum at the start of every DuckDB database file.
+var duckdbMagic = []byte("DUCK")
+
+const duckdbMagicOffset = 8
+
+// validateLocalPath checks that DuckDB can open the database file. A missing
+// file is fine as long as DuckDB can create it, which it doesn't in read-only
+// mode. An existing file must start with the DuckDB header, other files are
+// read with the table functions instead.
+func validateLocalPath(path string, readOnly bool) error {
+	if ext := strings.ToLower(filepath.Ext(path)); !databaseFileExtensions[ext] {
+		backend.Logger.Warn("Unexpected extension for a DuckDB database file, expected .duckdb or .db", "path", path, "extension", ext)
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if readOnly {
+			return &ConfigError{fmt.Sprintf("Database file %s does not exist, it cannot be created in read-only mode", path)}
+		}
+		dir := filepath.Dir(path)
+		if dirInfo, err := os.Stat(dir); err != nil || !dirInfo.IsDir() {
+			return &ConfigError{fmt.Sprintf("Directory %s of the database file does not exist", dir)}
+		}
+		return nil
+	}
+	if err != nil {
+		return &ConfigError{fmt.Sprintf("Cannot access database file %s: %v", path, err)}
+	}
+	if info.IsDir() {
+		return &ConfigError{fmt.Sprintf("Path %s is a directory, expected a database file", path)}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return &ConfigError{fmt.Sprintf("Database file %s is not readable: %v", path, err)}
+	}
+	defer f.Close()
+
+	if info.Size() == 0 {
+		return &ConfigError{fmt.Sprintf("Database file %s is empty, remove it to let DuckDB create a new database", path)}
+	}
+	header := make([]byte, duckdbMagicOffset+len(duckdbMagic))
+	if _, err := io.ReadFull(f, header); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return &ConfigError{fmt.Sprintf("Database file %s is not readable: %v", path, err)}
+	}
+	if !bytes.Equal(header[duckdbMagicOffset:], duckdbMagic) {
+		return &ConfigError{fmt.Sprintf("File %s is not a DuckDB database file. Point the path at a .duckdb file, or leave it empty and read CSV, Parquet or JSON files in the queries, e.g. with $__readFiles", path)}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/motherduckdb/grafana-duckdb-datasource/pkg/models"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	existing := createDatabaseFile(t, "CREATE TABLE t(i INTEGER)")
+	empty := filepath.Join(dir, "empty.duckdb")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	csv := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(csv, []byte("a,b\n1,2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	short := filepath.Join(dir, "short.db")
+	if err := os.WriteFile(short, []byte("DUCK"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unreadable := filepath.Join(dir, "unreadable.duckdb")
+	if err := os.WriteFile(unreadable, nil, 0o000); err != nil {
+		t.Fatal(err)
+	}
+	readOnlyDir := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnlyDir, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	token := &models.SecretPluginSettings{MotherDuckToken: "token"}
+
+	tests := []struct {
+		name    string
+		config  models.PluginSettings
+		message string
+	}{
+		{"in-memory", models.PluginSettings{}, ""},
+		{"motherduck", models.PluginSettings{Path: "md:my_db", Secrets: token}, ""},
+		{"existing file", models.PluginSettings{Path: existing}, ""},
+		{"existing file read-only", models.PluginSettings{Path: existing, ReadOnly: true}, ""},
+		{"new file", models.PluginSettings{Path: filepath.Join(dir, "new.duckdb")}, ""},
+		{"single quoted path", models.PluginSettings{Path: "'md:my_db'"}, "Invalid path"},
+		{"double quoted path", models.PluginSettings{Path: ` "/data/db.duckdb" `}, "Invalid path"},
+		{"motherduck without token", models.PluginSettings{Path: "md:my_db", Secrets: &models.SecretPluginSettings{}}, "MotherDuck Token is missing"},
+		{"motherduck without secrets", models.PluginSettings{Path: "md:my_db"}, "MotherDuck Token is missing"},
+		{"motherduck without database", models.PluginSettings{Path: "md:", Secrets: token}, "MotherDuck path md: is missing the database name -> example input: md:my_db"},
+		{"motherduck with spaces", models.PluginSettings{Path: " md:  my_db ", Secrets: token}, ""},
+		{"motherduck with space in name", models.PluginSettings{Path: "md:my db", Secrets: token}, `must not contain ' '`},
+		{"motherduck with semicolon", models.PluginSettings{Path: "md:my_db;", Secrets: token}, `must not contain ';'`},
+		{"motherduck read-only", models.PluginSettings{Path: "md:my_db", Secrets: token, ReadOnly: true}, "Read-only mode only applies to local database files"},
+		{"in-memory read-only", models.PluginSettings{ReadOnly: true}, "in-memory database cannot be opened read-only"},
+		{"missing file read-only", models.PluginSettings{Path: filepath.Join(dir, "missing.duckdb"), ReadOnly: true}, "does not exist"},
+		{"missing directory", models.PluginSettings{Path: filepath.Join(dir, "missing", "db.duckdb")}, "Directory"},
+		{"directory", models.PluginSettings{Path: dir}, "is a directory"},
+		{"empty file", models.PluginSettings{Path: empty}, "is empty"},
+		{"csv file", models.PluginSettings{Path: csv}, "is not a DuckDB database file"},
+		{"short file", models.PluginSettings{Path: short}, "is not a DuckDB database file"},
+		{"memory mode", models.PluginSettings{Mode: "memory"}, ""},
+		{"file mode", models.PluginSettings{Mode: "file", Path: existing}, ""},
+		{"motherduck mode", models.PluginSettings{Mode: " MotherDuck ", Path: "md:my_db", Secrets: token}, ""},
+		{"memory mode with path", models.PluginSettings{Mode: "memory", Path: existing}, "Mode memory opens an in-memory database"},
+		{"memory mode with md path", models.PluginSettings{Mode: "memory", Path: "md:my_db", Secrets: token}, "Mode memory opens an in-memory database"},
+		{"file mode without path", models.PluginSettings{Mode: "file", Path: " "}, "Mode file needs the path of a database file"},
+		{"file mode with md path", models.PluginSettings{Mode: "file", Path: "md:my_db", Secrets: token}, "is a MotherDuck path"},
+		{"motherduck mode without path", models.PluginSettings{Mode: "motherduck", Secrets: token}, "Mode motherduck needs an md: path"},
+		{"motherduck mode with file", models.PluginSettings{Mode: "motherduck", Path: existing, Secrets: token}, "Mode motherduck needs an md: path"},
+		{"invalid mode", models.PluginSettings{Mode: "disk", Path: existing}, "Invalid mode: disk"},
+		{"negative max rows", models.PluginSettings{MaxRows: -1}, "Invalid max rows"},
+		{"unreadable file", models.PluginSettings{Path: unreadable}, "is not readable"},
+		{"temp directory", models.PluginSettings{TempDirectory: dir}, ""},
+		{"missing temp directory", models.PluginSettings{TempDirectory: filepath.Join(dir, "missing")}, "does not exist or is not a directory"},
+		{"temp directory is a file", models.PluginSettings{TempDirectory: existing}, "does not exist or is not a directory"},
+		{"unwritable temp directory", models.PluginSettings{TempDirectory: readOnlyDir}, "is not writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.name == "unreadable file" || tt.name == "unwritable temp directory") && os.Geteuid() == 0 {
+				t.Skip("root ignores file permissions")
+			}
+			err := ValidateConfig(&tt.config)
+			if tt.message == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("expected a ConfigError, got %v", err)
+			}
+			if !strings.Contains(configErr.Msg, tt.message) {
+				t.Errorf("expected %q in %q", tt.message, configErr.Msg)
+			}
+		})
+	}
+}
+
+func TestCheckHealthValidatesConfig(t *testing.T) {
+	path := createDatabaseFile(t, "CREATE TABLE t(i INTEGER)")
+	ds := NewDatasource(&DuckDBDriver{Initialized: false})
+	_, err := ds.NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(fmt.Sprintf(`{"path": %q, "readOnly": true}`, path)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusError || !strings.Contains(res.Message, "Configuration error: Database file") {
+		t.Errorf("expected a configuration error, got %v %q", res.Status, res.Message)
+	}
+}
+
+func TestValidateLocalPathExtension(t *testing.T) {
+	logs := recordLogs(t)
+	dir := t.TempDir()
+
+	if err := validateLocalPath(filepath.Join(dir, "new.duckdb"), false); err != nil {
+		t.Fatal(err)
+	}
+	if entries := logs.messages(); len(entries) != 0 {
+		t.Errorf("expected no warning for a .duckdb file, got %+v", entries)
+	}
+
+	// Unexpected extensions are only a warning, DuckDB can still create the file.
+	if err := validateLocalPath(filepath.Join(dir, "new.parquet"), false); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.messages()
+	if len(entries) != 1 || entries[0].level != "warn" {
+		t.Fatalf("expected a warning, got %+v", entries)
+	}
+	if ext, _ := entries[0].field("extension"); ext != ".parquet" {
+		t.Errorf("expected the extension as a field, got %+v", entries[0].args)
+	}
+}
+
+func TestConnectLogsDatabaseMode(t *testing.T) {
+	existing := createDatabaseFile(t, "CREATE TABLE t(i INTEGER)")
+	tests := []struct {
+		name     string
+		jsonData string
+		mode     string
+		explicit bool
+		warning  bool
+	}{
+		{"implicit memory", `{"path": ""}`, "memory", false, true},
+		{"memory", `{"path": "", "mode": "memory"}`, "memory", true, false},
+		{"implicit file", fmt.Sprintf(`{"path": %q}`, existing), "file", false, false},
+		{"file", fmt.Sprintf(`{"path": %q, "mode": "file"}`, existing), "file", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := recordLogs(t)
+			driver := &DuckDBDriver{}
+			db, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(tt.jsonData)}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			selected, warned := false, false
+			for _, entry := range logs.messages() {
+				if entry.msg == "Selected database mode" {
+					mode, _ := entry.field("mode")
+					explicit, _ := entry.field("explicit")
+					selected = mode == tt.mode && explicit == tt.explicit
+				}
+				warned = warned || (entry.level == "warn" && strings.Contains(entry.msg, "in-memory database"))
+			}
+			if !selected {
+				t.Errorf("expected mode %s (explicit %t) to be logged, got %+v", tt.mode, tt.explicit, logs.messages())
+			}
+			if warned != tt.warning {
+				t.Errorf("expected the in-memory warning %t, got %t", tt.warning, warned)
+			}
+		})
+	}
+
+	// A mismatch fails the connection with a configuration error.
+	driver := &DuckDBDriver{}
+	_, err := driver.Connect(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"path": "", "mode": "file"}`)}, nil)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || !strings.Contains(err.Error(), "Mode file") {
+		t.Fatalf("expected a ConfigError for the mode, got %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import { Aggregate, DB, ResponseParser, SQLOptions, SQLQuery, SQLSelectableValue
 import { applyQueryDefaults } from './queryDefaults';
 import { VariableFormatID } from '@grafana/schema';
 import { getFieldConfig, toRawSql } from './sqlUtil';
+import { DuckDBQuery } from './types';
 
 import {
   ColumnDefinition,
@@ -28,6 +29,9 @@ interface MetricFindQueryOptions extends SearchFilterOptions {
 
 const SEARCH_FILTER_VARIABLE = '__searchFilter';
 
+// Queries with this query type are turned into annotation events by the backend.
+export const ANNOTATION_QUERY_TYPE = 'annotation';
+
 const containsSearchFilter = (query: string | unknown): boolean =>
   query && typeof query === 'string' ? query.indexOf(SEARCH_FILTER_VARIABLE) !== -1 : false;
 
@@ -54,6 +58,15 @@ const getSearchFilterScopedVar = (args: {
   };
 };
 
+// resolveTimezone returns the IANA name of a dashboard timezone, which is
+// "browser" when it follows the browser.
+function resolveTimezone(timezone: string): string {
+  if (timezone === 'browser') {
+    return Intl.DateTimeFormat().resolvedOptions().timeZone;
+  }
+  return timezone;
+}
+
 export function formatSQL(q: string) {
   return sqlFormatter.format(q).replace(/(\$ \{ .* \})|(\$ __)|(\$ \w+)/g, (m: string) => {
     return m.replace(/\s/g, '');
@@ -178,18 +191,23 @@ async function functions(): Promise<Aggregate[]> {
 export class DuckDBDataSource extends SqlDatasource {
   sqlLanguageDefinition: LanguageDefinition | undefined = undefined;
 
-  query(request: DataQueryRequest<SQLQuery>) {;
-    const result = super.query(request);
+  query(request: DataQueryRequest<SQLQuery>) {
+    const timezone = resolveTimezone(request.timezone);
+    const targets: DuckDBQuery[] = request.targets.map((target) => ({ ...target, timezone }));
+    const result = super.query({ ...request, targets });
     return result;
   }
 
-  applyTemplateVariables(target: SQLQuery, scopedVars: ScopedVars): SQLQuery {
+  applyTemplateVariables(target: DuckDBQuery, scopedVars: ScopedVars): DuckDBQuery {
     const queryModel = this.getQueryModel(target, this.templateSrv, scopedVars);
     return {
       refId: target.refId,
       datasource: this.getRef(),
       rawSql: queryModel.interpolate(),
       format: target.format,
+      queryType: target.queryType,
+      timezone: target.timezone,
+      params: target.params,
     };
   }
 
@@ -325,6 +343,22 @@ export class DuckDBDataSource extends SqlDatasource {
   
   constructor(instanceSettings: DataSourceInstanceSettings<SQLOptions>) {
     super(instanceSettings);
+    // Annotation queries are edited in the query editor and sent with the
+    // annotation query type, the backend returns their rows as events.
+    this.annotations = {
+      prepareQuery: (anno) => {
+        const target = anno.target;
+        if (!target?.rawSql) {
+          return undefined;
+        }
+        return {
+          ...target,
+          refId: target.refId || 'Anno',
+          queryType: ANNOTATION_QUERY_TYPE,
+          format: QueryFormat.Table,
+        };
+      },
+    };
   }
 
 }
@@ -1,4 +1,4 @@
-import { SQLOptions } from '@grafana/plugin-ui';
+import { SQLOptions, SQLQuery } from '@grafana/plugin-ui';
 
 
 // export interface DuckDBQuery extends SQLQuery {
@@ -19,6 +19,16 @@ import { SQLOptions } from '@grafana/plugin-ui';
 //   datapoints: DataPoint[];
 // }
 
+/**
+ * A query as sent to the backend. timezone is the IANA name of the dashboard
+ * timezone, used when the useQueryTimezone option is enabled. params are bound
+ * to the positional parameters of rawSql.
+ */
+export interface DuckDBQuery extends SQLQuery {
+  timezone?: string;
+  params?: Array<string | number | boolean | null>;
+}
+
 /**
  * These are options configured for each DataSource instance
  */